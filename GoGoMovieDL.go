@@ -4,10 +4,12 @@ import (
 	"encoding/csv"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
-
-	"database/sql"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/jasonlvhit/gocron"
 	"github.com/pelletier/go-toml"
@@ -19,17 +21,25 @@ import (
 )
 
 var (
-	MYAPIKEY         string  //NZBGeek API
-	MYSABURL         string  //SABNZBD URL
-	MYSABAPI         string  //SABNZBD API Key
-	MYSABCAT         string  //SABNZBD Category
-	MYRSS2FEEDURL    string  //RSS2 Watchlist URL
-	MYRSSCHECK       int64   //IMDB Watchlist Check Interval in minutes, recommend 120
-	MYMOVIECHECK     int64   //Specific Movie Check Interval in minutes, recommend 400
-	MYMOVIESCHECK    int64   //Recent Movies Check Interval in minutes, recommend 16 mins
-	MYPREFERREDWORDS string  //Preferred words list, comma separated, increase score
-	MYBANNEDWORDS    string  //Banned words list, comma separated, kill score
-	db               *sql.DB //Global DB Handle
+	MYAPIKEY           string //NZBGeek API, used when no [[INDEXER]] tables are configured
+	MYSABURL           string //SABNZBD URL, used when no [[DOWNLOADER]] tables are configured
+	MYSABAPI           string //SABNZBD API Key
+	MYSABCAT           string //SABNZBD Category
+	MYRSS2FEEDURL      string //RSS2 Watchlist URL
+	MYRSSCHECK         int64  //IMDB Watchlist Check Interval in minutes, recommend 120
+	MYMOVIECHECK       int64  //Specific Movie Check Interval in minutes, recommend 400
+	MYMOVIESCHECK      int64  //Recent Movies Check Interval in minutes, recommend 16 mins
+	MYPREFERREDWORDS   string //Preferred words list, comma separated, increase score
+	MYBANNEDWORDS      string //Banned words list, comma separated, kill score
+	MYBANNEDSOURCES    string //Banned release sources, comma separated e.g. CAM,TS,TC,SCR
+	MYMINSEEDERS       int    //Torrents with fewer seeders than this are ignored
+	MYSTALLTIMEOUT     int64  //Minutes a download can sit at the same percentage, or out of a blackhole's sight, before it's dropped, 0 to never
+	MYFETCHTIMEOUT     int64  //Minutes a download can spend fetching its nzb before it's dropped, 0 to never
+	MYUPGRADES         bool   //Keep looking for better releases until the profile cutoff is met
+	MYCANCELREMOVED    bool   //Cancel downloads of movies that leave the watchlist
+	MYMAXREMOVEPERCENT int64  //Most of the watchlist that can be archived in one update
+	MYDBDSN            string //Postgres url to share a database, "memory" to keep nothing, blank for GoGoMovieDL.db
+	db                 *DB    //Global DB Handle
 )
 
 type RSS2 struct {
@@ -42,6 +52,7 @@ type Item struct {
 	Title   string `xml:"title"`
 	Link    string `xml:"link"`
 	PubDate string `xml:"pubDate"`
+	Runtime int    `xml:"-"` //minutes, only in the csv
 }
 
 func main() {
@@ -59,33 +70,67 @@ func main() {
 	//read global settings from file
 	ReadConfig()
 
+	//GoGoMovieDL migrate, backup or restore file - just do that to the database and exit
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			err = MigrateCommand()
+		case "backup":
+			err = BackupCommand()
+		case "restore":
+			err = RestoreCommand(os.Args[2:])
+		default:
+			err = errors.New("unknown command, want migrate, backup or restore")
+		}
+		if err != nil {
+			log.Printf("Main:%s:%v", os.Args[1], err)
+			fmt.Fprintln(os.Stderr, os.Args[1]+":", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	//initialise database, create if not already created etc.
-	err = InitDB()
-	defer db.Close()
+	st, err := InitStore()
 	if err != nil {
-		log.Panic("Main:InitDB", err)
+		log.Panic("Main:InitStore", err)
 	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	//[[RULE]] tables may have changed since last time
+	SyncConfigRules(st)
+	LoadSourceStats(st)
 
 	//Start webserver in another channel, in case templates fail
-	InitWebServer()
+	InitWebServer(st)
 
 	//Update Scores to support possible config preferred/bad changes
-	UpdateNZBScores()
+	UpdateNZBScores(st)
+
+	//match up duplicates from before they were tracked
+	GroupReleases(st)
 
 	//set up timed jobs
 	//will run every x minutes, as defined in file
 
-	gocron.Every(uint64(MYRSSCHECK)).Minutes().Do(RSS2WatchlistUpdate)
-	gocron.Every(uint64(MYMOVIESCHECK)).Minutes().Do(MostRecentMovieList)
-	gocron.Every(uint64(MYMOVIECHECK)).Minutes().Do(UnGrabbedMovies)
+	gocron.Every(uint64(MYRSSCHECK)).Minutes().Do(Jobs["watchlist"].Scheduled, st)
+	gocron.Every(uint64(MYMOVIESCHECK)).Minutes().Do(Jobs["latest"].Scheduled, st)
+	gocron.Every(uint64(MYMOVIECHECK)).Minutes().Do(Jobs["search"].Scheduled, st)
 
-	gocron.Every(2).Minutes().Do(DownloadGrabbableMovies)
+	gocron.Every(2).Minutes().Do(Jobs["grab"].Scheduled, st)
+
+	//only sqlite, postgres has pg_dump
+	if MYBACKUPINTERVAL > 0 && db != nil && db.Dialect == SQLite {
+		gocron.Every(uint64(MYBACKUPINTERVAL)).Minutes().Do(Jobs["backup"].Scheduled, st)
+	}
 
 	//Also run on load
 
-	RSS2WatchlistUpdate()
-	MostRecentMovieList()
-	DownloadGrabbableMovies()
+	Jobs["watchlist"].Scheduled(st)
+	Jobs["latest"].Scheduled(st)
+	Jobs["grab"].Scheduled(st)
 
 	//Start Cronjobs
 	<-gocron.Start()
@@ -110,16 +155,26 @@ func CSV2Feed(URL string) (*RSS2, error) {
 	}
 
 	Newrss2 := new(RSS2)
+	runtimecol := -1
 	for idx, row := range data {
 
-		//skip header
+		//skip header, but find the runtime in it
 		if idx == 0 {
+			for col, name := range row {
+				if strings.HasPrefix(strings.ToLower(name), "runtime") {
+					runtimecol = col
+				}
+			}
 			continue
 		}
 
 		Newitem.Link = row[6]
 		Newitem.PubDate = row[2]
 		Newitem.Title = row[5]
+		Newitem.Runtime = 0
+		if runtimecol >= 0 && runtimecol < len(row) {
+			Newitem.Runtime, _ = strconv.Atoi(row[runtimecol])
+		}
 		Newrss2.Items = append(Newrss2.Items, Newitem)
 	}
 
@@ -157,76 +212,147 @@ func RSS2Feed(URL string) (*RSS2, error) {
 
 // Get the feed from the MYRSS2FEEDURL and
 // update the database
-func RSS2WatchlistUpdate() {
+func RSS2WatchlistUpdate(st Store) {
 	log.Println("RSS2WatchlistUpdate")
 	iv, err := CSV2Feed(MYRSS2FEEDURL)
 	if err != nil {
 		log.Println("Main:RSS2WatchlistUpdate:RSS2Feed:", err)
 		return
 	}
-	added := RSS2toDB(iv)
-	removed := DBRemoveMissingRSS2(iv)
-	log.Printf("RSS2WatchlistUpdate:End:%d added, %d removed", added, removed)
+	added := RSS2toDB(st, iv)
+	removed := DBRemoveMissingRSS2(st, iv)
+	log.Printf("RSS2WatchlistUpdate:End:%d added, %d archived", added, removed)
 }
 
-// Get the latest movie list and if there are files
-// for movies we have then add them
-func MostRecentMovieList() {
-	//LATEST MOVIES
-	log.Println("Main:MostRecentMovieList:Begin")
-	nz, err := NZBGeekMovies(MYAPIKEY)
-	if err != nil {
-		log.Println("Main:MostRecentMovieList:NZBGeekMovies", err)
-	} else {
-		count := NZBGRSStoDB(nz)
-		log.Printf("Main:MostRecentMovieList:End:%d added", count)
+// loop the imdb rss and if the
+// movie doesn't already exist we add to
+// the Movies table
+func RSS2toDB(st Store, rs *RSS2) (count int) {
+	var rescore bool
+
+	for _, mv := range rs.Items {
+		//Get ID as INT64
+		id, err := TTtoID(mv.Link)
+		if err != nil {
+			log.Printf("Couldn't find ID - %s %s %+v", mv.Title, mv.Link, err)
+		}
+
+		added, err := st.AddMovie(id, mv.Title, mv.Runtime)
+		switch {
+		case err != nil:
+			log.Println("RSS2DB:AddMovie:", err)
+		case added:
+			log.Printf("RSS2DB:Added Movie %s with ID:%d", mv.Title, id)
+			st.LogEvent(id, EventAdded, "", "watchlist", mv.Title)
+			count += 1
+		default:
+			//already have it, back on the watchlist if it was archived
+			if st.RestoreMovie(id) {
+				log.Printf("RSS2DB:Restored archived movie %s with ID:%d", mv.Title, id)
+				st.LogEvent(id, EventRestored, "", "watchlist", "Back on the watchlist")
+			}
+			//older lists didn't give us the runtime
+			if mv.Runtime > 0 && st.SetMovieRuntime(id, mv.Runtime) {
+				rescore = true
+			}
+		}
+	}
+	if rescore {
+		UpdateNZBScores(st)
 	}
+	return count
 }
 
-// Only meant to be run rarely (4 times daily max) - this will scroll all our
-// ungrabbed movies and will see if there are any files available
-func UnGrabbedMovies() {
-	var (
-		id  int64
-		ids []int64
-	)
-	log.Println("Main:UnGrabbedMovies:Begin")
-	rows, err := db.Query(`
-		PRAGMA read_uncommitted = 1;
-		select distinct id from movies where grabbed=0
-	`)
-	if err != nil {
-		log.Println("Main:UnGrabbedMovies:Query", err)
-		return
-	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&id)
+// archive movies in the db that aren't in rs, unless that's more than
+// MYMAXREMOVEPERCENT of them - more likely a truncated download than
+// a cleared out watchlist. One at a time is always fine.
+func DBRemoveMissingRSS2(st Store, rs *RSS2) (count int) {
+	//make map from rs
+	rsItems := make(map[int64]bool)
+	for _, mv := range rs.Items {
+		id, err := TTtoID(mv.Link)
 		if err != nil {
-			log.Println("Main:UnGrabbedMovies:RowScan", err)
+			//do nothing
+		} else {
+			rsItems[id] = true
 		}
-		ids = append(ids, id)
 	}
 
-	for _, id := range ids {
-		nz, err := NZBGeekMovieByIMDB(id, MYAPIKEY)
+	if len(rsItems) > 0 {
+		//get list of movies from db, not the ones added through the api
+		var movs, missing []Movie
+		for _, mov := range st.Movies() {
+			if mov.Manual {
+				continue
+			}
+			movs = append(movs, mov)
+			//is movie id in map?
+			_, ok := rsItems[mov.Id]
+			if !ok {
+				missing = append(missing, mov)
+			}
+		}
+		if len(missing) > 1 && int64(len(missing))*100 > MYMAXREMOVEPERCENT*int64(len(movs)) {
+			log.Printf("DBRemoveMissingRSS2:Not removing %d of %d movies, over MYMAXREMOVEPERCENT %d%%", len(missing), len(movs), MYMAXREMOVEPERCENT)
+			st.LogEvent(0, EventArchiveRefuse, "", "watchlist", fmt.Sprintf("%d of %d movies missing, over MYMAXREMOVEPERCENT %d%%", len(missing), len(movs), MYMAXREMOVEPERCENT))
+			return 0
+		}
+		for _, mov := range missing {
+			if st.ArchiveMovie(mov.Id) {
+				log.Printf("Movie not in watchlist, archived %d : %s", mov.Id, mov.Title)
+				st.LogEvent(mov.Id, EventArchived, "", "watchlist", "Not in the watchlist")
+				count += 1
+				if MYCANCELREMOVED {
+					CancelMovieDownloads(st, mov.Id)
+				}
+			}
+		}
+	}
+	return count
+}
+
+// Get the latest movie list and if there are files
+// for movies we have then add them
+func MostRecentMovieList(st Store) {
+	//LATEST MOVIES
+	log.Println("Main:MostRecentMovieList:Begin")
+	for _, ix := range Indexers {
+		nz, err := ix.LatestMovies()
 		if err != nil {
-			log.Println("UngrabbedMovies:GetByID", err)
+			log.Printf("Main:MostRecentMovieList:%s:LatestMovies %v", ix.Name(), err)
 		} else {
-			NZBGRSStoDB(nz)
+			count := NZBGRSStoDB(st, nz)
+			log.Printf("Main:MostRecentMovieList:%s:%d added", ix.Name(), count)
+		}
+	}
+	log.Println("Main:MostRecentMovieList:End")
+}
+
+// Only meant to be run rarely (4 times daily max) - this will scroll all our
+// ungrabbed movies and will see if there are any files available, along
+// with downloaded movies still short of their profile's cutoff
+func UnGrabbedMovies(st Store) {
+	log.Println("Main:UnGrabbedMovies:Begin")
+	for _, mv := range st.WantedMovies(MYUPGRADES) {
+		if mv.Grabbed == 1 && ProfileByName(mv.Profile).CutoffMet(mv.DlQuality) {
+			continue
 		}
+		SearchIndexersForMovie(st, mv.Id, mv.Title)
 	}
 	log.Println("Main:UnGrabbedMovies:End")
 }
 
 // Download teh ungrabbed movies that have files attached
-func DownloadGrabbableMovies() {
+func DownloadGrabbableMovies(st Store) {
 	//Parse History First to remove complete and allow us to get next if failed
-	SABParseHistory()
+	ParseDownloads(st)
 	//Look for non grabbed nzbs with score>0 and not ignored or grabbed
-	gb := GrabbableList()
+	gb := GrabbableList(st, DownloadProtocols())
 	for _, gbb := range gb {
-		SABGrabAndMark(gbb.Id, gbb.MovieId)
+		if gbb.Upgrade {
+			log.Printf("Main:DownloadGrabbableMovies:Upgrading %s to %s", gbb.MovieTitle, gbb.Quality)
+		}
+		GrabAndMark(st, gbb.Id, gbb.MovieId, "scheduler")
 	}
 }
 
@@ -236,13 +362,25 @@ func ReadConfig() {
 	if err != nil {
 		log.Panic("ReadConfig:", err)
 	} else {
-		MYAPIKEY = config.Get("MYAPIKEY").(string)
-		MYSABURL = ReturnNiceSABURL(config.Get("MYSABURL").(string))
-		MYSABAPI = config.Get("MYSABAPI").(string)
-		MYSABCAT = config.Get("MYSABCAT").(string)
+		MYAPIKEY = config.GetDefault("MYAPIKEY", "").(string)
+		MYSABURL = config.GetDefault("MYSABURL", "").(string)
+		MYSABAPI = config.GetDefault("MYSABAPI", "").(string)
+		MYSABCAT = config.GetDefault("MYSABCAT", "").(string)
 		MYRSS2FEEDURL = config.Get("MYRSS2FEEDURL").(string)
 		MYBANNEDWORDS = config.Get("MYBANNEDWORDS").(string)
 		MYPREFERREDWORDS = config.Get("MYPREFERREDWORDS").(string)
+		MYBANNEDSOURCES = config.GetDefault("MYBANNEDSOURCES", "CAM,TS,TC,SCR").(string)
+		MYMINSEEDERS = int(config.GetDefault("MYMINSEEDERS", int64(1)).(int64))
+		MYSTALLTIMEOUT = config.GetDefault("MYSTALLTIMEOUT", int64(240)).(int64)
+		MYFETCHTIMEOUT = config.GetDefault("MYFETCHTIMEOUT", int64(30)).(int64)
+		MYUPGRADES = config.GetDefault("MYUPGRADES", true).(bool)
+		MYCANCELREMOVED = config.GetDefault("MYCANCELREMOVED", false).(bool)
+		MYDBDSN = config.GetDefault("MYDBDSN", "").(string)
+		MYMAXREMOVEPERCENT = config.GetDefault("MYMAXREMOVEPERCENT", int64(10)).(int64)
+		MYBACKUPDIR = config.GetDefault("MYBACKUPDIR", "./backups").(string)
+		MYBACKUPINTERVAL = config.GetDefault("MYBACKUPINTERVAL", int64(1440)).(int64)
+		MYBACKUPKEEP = int(config.GetDefault("MYBACKUPKEEP", int64(7)).(int64))
+		MYWEBAPIKEY = config.GetDefault("MYWEBAPIKEY", "").(string)
 
 		//don't want to check any sooner than every 10 mins
 		MYRSSCHECK = config.Get("MYRSSCHECK").(int64)
@@ -261,6 +399,16 @@ func ReadConfig() {
 			MYMOVIESCHECK = 15
 		}
 
+		ReadIndexers(config)
+		ReadProfiles(config)
+		ReadRules(config)
+		MYSCORESCRIPT = config.GetDefault("MYSCORESCRIPT", "").(string)
+		MYFAILLIMIT = int(config.GetDefault("MYFAILLIMIT", int64(3)).(int64))
+		MYFAILPENALTY = float64(config.GetDefault("MYFAILPENALTY", int64(250)).(int64))
+		MYGROUPBONUS = float64(config.GetDefault("MYGROUPBONUS", int64(500)).(int64))
+		LoadScoreScript()
+		ReadDownloadClients(config)
+
 	}
 	log.Println("Main:ReadConfig:End")
 }
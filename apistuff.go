@@ -0,0 +1,382 @@
+//apistuff.go
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// A JSON api under /api/v1 for scripts and dashboards, doing what
+// the web pages do. Every request needs MYWEBAPIKEY in an X-Api-Key
+// header or an apikey parameter, with no MYWEBAPIKEY the api is off.
+// Errors come back as {"error": "why"}.
+
+var MYWEBAPIKEY string //Key for the json api, blank to turn it off
+
+// a movie as the api shows it
+type apiMovie struct {
+	Id          int64      `json:"id"`
+	IMDB        string     `json:"imdb"`
+	Title       string     `json:"title"`
+	Runtime     int        `json:"runtime"` //minutes, 0 if we don't know
+	Profile     string     `json:"profile"`
+	Grabbed     bool       `json:"grabbed"`
+	NzbCount    int        `json:"nzbcount"`
+	IgnoreCount int        `json:"ignorecount"`
+	CoverUrl    string     `json:"coverurl"`
+	DlGuid      string     `json:"dlguid"` //what was downloaded, blank if nothing yet
+	DlTitle     string     `json:"dltitle"`
+	DlQuality   string     `json:"dlquality"`
+	DlScore     float64    `json:"dlscore"`
+	Manual      bool       `json:"manual"`             //added through the api
+	Archived    *time.Time `json:"archived,omitempty"` //when it left the watchlist
+}
+
+// an nzb or torrent, no link as that has the indexer's key in it
+type apiRelease struct {
+	Guid        string         `json:"guid"`
+	MovieId     int64          `json:"movieid"`
+	Title       string         `json:"title"`
+	Protocol    string         `json:"protocol"`
+	Indexer     string         `json:"indexer"`
+	Size        float64        `json:"size"`
+	Grabs       int            `json:"grabs"`
+	Seeders     int            `json:"seeders"`
+	Peers       int            `json:"peers"`
+	Date        time.Time      `json:"date"`
+	Quality     string         `json:"quality"`
+	Rank        int            `json:"rank"` //in the movie's quality profile, -1 if not allowed
+	Group       string         `json:"group"`
+	Score       float64        `json:"score"`
+	ScoreDetail ScoreBreakdown `json:"scoredetail"`
+	Grabbed     bool           `json:"grabbed"`
+	Ignored     bool           `json:"ignored"`
+	ReleaseId   string         `json:"releaseid"` //guid of the first copy of this release
+}
+
+// a download in flight
+type apiDownload struct {
+	MovieId      int64     `json:"movieid"`
+	Movie        string    `json:"movie"`
+	Title        string    `json:"title"`
+	Guid         string    `json:"guid"`
+	Client       string    `json:"client"`
+	DlId         string    `json:"dlid"`
+	Status       string    `json:"status"`
+	Percentage   int       `json:"percentage"`
+	MBLeft       float64   `json:"mbleft"`
+	TimeLeft     string    `json:"timeleft"`
+	Added        time.Time `json:"added"`
+	LastProgress time.Time `json:"lastprogress"`
+}
+
+// what POST /movies takes, imdb is tt0133093 or an imdb url
+type apiNewMovie struct {
+	IMDB    string `json:"imdb"`
+	Title   string `json:"title"`
+	Runtime int    `json:"runtime"`
+}
+
+func toAPIMovie(mv Movie) apiMovie {
+	am := apiMovie{Id: mv.Id, IMDB: fmt.Sprintf("tt%07d", mv.Id), Title: mv.Title, Runtime: mv.Runtime, Profile: mv.Profile,
+		Grabbed: mv.Grabbed == 1, NzbCount: mv.NzbCount, IgnoreCount: mv.IgnoreCount, CoverUrl: mv.CoverUrl,
+		DlGuid: mv.DlGuid, DlTitle: mv.DlTitle, DlQuality: mv.DlQuality, DlScore: mv.DlScore, Manual: mv.Manual}
+	if !mv.Archived.IsZero() {
+		am.Archived = &mv.Archived
+	}
+	return am
+}
+
+// ranked in its movie's profile, same as the movie page
+func toAPIRelease(nz NZB) apiRelease {
+	nz.Rank = ProfileByName(nz.Profile).Rank(nz.Quality)
+	return apiRelease{Guid: nz.Id, MovieId: nz.MovieId, Title: nz.Title, Protocol: nz.Protocol, Indexer: nz.Indexer,
+		Size: nz.Size, Grabs: nz.Grabs, Seeders: nz.Seeders, Peers: nz.Peers, Date: nz.UsenetDate,
+		Quality: nz.Quality, Rank: nz.Rank, Group: nz.Group, Score: nz.Score, ScoreDetail: nz.ScoreDetail,
+		Grabbed: nz.Grabbed == 1, Ignored: nz.Ignored == 1, ReleaseId: nz.ReleaseId}
+}
+
+func toAPIDownload(dl Downloads) apiDownload {
+	return apiDownload{MovieId: dl.MovieID, Movie: dl.Nicename, Title: dl.Title, Guid: dl.Guid, Client: dl.DlMethod,
+		DlId: dl.DlId, Status: DownloadStatusName(dl.Status), Percentage: dl.Percentage, MBLeft: dl.MBLeft,
+		TimeLeft: dl.TimeLeft, Added: dl.Added, LastProgress: dl.LastProgress}
+}
+
+// v as json with status code
+func apiJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Print("Apistuff:Encode:", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, why string) {
+	apiJSON(w, code, map[string]string{"error": why})
+}
+
+//By for an event from the api, with who it came from
+func apiBy(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "api " + host
+}
+
+// turn away anything without the key
+func (ws *WebServer) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if MYWEBAPIKEY == "" {
+			apiError(w, http.StatusForbidden, "the api is off, set MYWEBAPIKEY to turn it on")
+			return
+		}
+		key := r.Header.Get("X-Api-Key")
+		if key == "" {
+			key = r.URL.Query().Get("apikey")
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(MYWEBAPIKEY)) != 1 {
+			log.Printf("Apistuff:APIAuth:Bad key from %s", r.RemoteAddr)
+			apiError(w, http.StatusUnauthorized, "missing or wrong api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the movie in the path, false and a 404 sent if we haven't got it
+func (ws *WebServer) apiMovie(w http.ResponseWriter, r *http.Request) (Movie, bool) {
+	movieid, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	mv, err := ws.st.Movie(movieid)
+	if err == ErrNotFound {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("no movie %d", movieid))
+		return mv, false
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return mv, false
+	}
+	return mv, true
+}
+
+// the release in the path, it has to belong to the movie in the path
+func (ws *WebServer) apiRelease(w http.ResponseWriter, r *http.Request) (NZB, bool) {
+	vars := mux.Vars(r)
+	movieid, _ := strconv.ParseInt(vars["id"], 10, 64)
+	nz, err := ws.st.Release(vars["guid"])
+	if err == ErrNotFound || (err == nil && nz.MovieId != movieid) {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("no release %s for movie %d", vars["guid"], movieid))
+		return nz, false
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return nz, false
+	}
+	return nz, true
+}
+
+//GET /movies, ?archived=1 for the archived ones instead
+func (ws *WebServer) APIMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var mvs []Movie
+	if r.URL.Query().Get("archived") == "1" {
+		mvs = ws.st.ArchivedMovies()
+	} else {
+		mvs = ws.st.Movies()
+	}
+	ams := []apiMovie{}
+	for _, mv := range mvs {
+		ams = append(ams, toAPIMovie(mv))
+	}
+	apiJSON(w, http.StatusOK, ams)
+}
+
+//GET /movies/{id}
+func (ws *WebServer) APIMovieHandler(w http.ResponseWriter, r *http.Request) {
+	mv, ok := ws.apiMovie(w, r)
+	if !ok {
+		return
+	}
+	for _, nz := range ws.st.Releases(mv.Id) {
+		mv.NzbCount++
+		mv.IgnoreCount += nz.Ignored
+	}
+	apiJSON(w, http.StatusOK, toAPIMovie(mv))
+}
+
+//POST /movies - add a movie that isn't on the watchlist, or bring
+//back an archived one. Either way the watchlist leaves it alone.
+func (ws *WebServer) APIAddMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var nm apiNewMovie
+	err := json.NewDecoder(r.Body).Decode(&nm)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "bad json: "+err.Error())
+		return
+	}
+	id, err := TTtoID(nm.IMDB)
+	if err != nil || id <= 0 {
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("imdb %q isn't an imdb id like tt0133093", nm.IMDB))
+		return
+	}
+	if nm.Title == "" {
+		apiError(w, http.StatusBadRequest, "title is needed")
+		return
+	}
+	added, err := ws.st.AddMovie(id, nm.Title, nm.Runtime)
+	if err != nil {
+		log.Println("Apistuff:AddMovie:", err)
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	code := http.StatusCreated
+	switch {
+	case added:
+		log.Printf("Apistuff:Added Movie %s with ID:%d", nm.Title, id)
+		ws.st.LogEvent(id, EventAdded, "", apiBy(r), nm.Title)
+	case ws.st.RestoreMovie(id):
+		log.Printf("Apistuff:Restored archived movie %s with ID:%d", nm.Title, id)
+		ws.st.LogEvent(id, EventRestored, "", apiBy(r), "Added through the api")
+		code = http.StatusOK
+	default:
+		apiError(w, http.StatusConflict, fmt.Sprintf("already have movie %d", id))
+		return
+	}
+	ws.st.SetMovieManual(id, true)
+	mv, _ := ws.st.Movie(id)
+	apiJSON(w, code, toAPIMovie(mv))
+}
+
+//DELETE /movies/{id} - archive it, ?purge=1 deletes an archived
+//movie and everything about it for good
+func (ws *WebServer) APIRemoveMovieHandler(w http.ResponseWriter, r *http.Request) {
+	mv, ok := ws.apiMovie(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("purge") == "1" {
+		//its downloads went when it was archived
+		if mv.Archived.IsZero() {
+			apiError(w, http.StatusBadRequest, "only archived movies can be purged")
+			return
+		}
+		if !ws.st.DeleteMovie(mv.Id) {
+			apiError(w, http.StatusInternalServerError, "couldn't delete, see the log")
+			return
+		}
+		ws.st.LogEvent(mv.Id, EventPurged, "", apiBy(r), mv.Title)
+	} else if mv.Archived.IsZero() {
+		if !ws.st.ArchiveMovie(mv.Id) {
+			apiError(w, http.StatusInternalServerError, "couldn't archive, see the log")
+			return
+		}
+		ws.st.LogEvent(mv.Id, EventArchived, "", apiBy(r), "Removed through the api")
+		if MYCANCELREMOVED {
+			CancelMovieDownloads(ws.st, mv.Id)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//GET /movies/{id}/releases - ignored last, then best score first
+func (ws *WebServer) APIReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	mv, ok := ws.apiMovie(w, r)
+	if !ok {
+		return
+	}
+	ars := []apiRelease{}
+	for _, nz := range ws.st.Releases(mv.Id) {
+		ars = append(ars, toAPIRelease(nz))
+	}
+	apiJSON(w, http.StatusOK, ars)
+}
+
+//POST /movies/{id}/refresh - search the indexers for it now
+func (ws *WebServer) APIRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	mv, ok := ws.apiMovie(w, r)
+	if !ok {
+		return
+	}
+	count := SearchIndexersForMovie(ws.st, mv.Id, mv.Title)
+	log.Printf("Apistuff:Refresh:%d:%d added", mv.Id, count)
+	ws.st.LogEvent(mv.Id, EventSearched, "", apiBy(r), fmt.Sprintf("%d added", count))
+	apiJSON(w, http.StatusOK, map[string]int{"added": count})
+}
+
+//POST /movies/{id}/releases/{guid}/grab - send it to the download client
+func (ws *WebServer) APIGrabHandler(w http.ResponseWriter, r *http.Request) {
+	nz, ok := ws.apiRelease(w, r)
+	if !ok {
+		return
+	}
+	if !GrabAndMark(ws.st, nz.Id, nz.MovieId, apiBy(r)) {
+		apiError(w, http.StatusBadGateway, fmt.Sprintf("couldn't send %s to a %s download client, see the log", nz.Title, nz.Protocol))
+		return
+	}
+	nz, _ = ws.st.Release(nz.Id)
+	apiJSON(w, http.StatusOK, toAPIRelease(nz))
+}
+
+//POST /movies/{id}/releases/{guid}/ignore and .../unignore
+func (ws *WebServer) APIIgnoreHandler(w http.ResponseWriter, r *http.Request) {
+	nz, ok := ws.apiRelease(w, r)
+	if !ok {
+		return
+	}
+	if mux.Vars(r)["action"] == "ignore" {
+		ws.st.SetReleaseFlags(nz.Id, 0, 1)
+		ws.st.LogEvent(nz.MovieId, EventIgnored, nz.Id, apiBy(r), nz.Title)
+	} else {
+		ws.st.SetReleaseFlags(nz.Id, 0, 0)
+		ws.st.LogEvent(nz.MovieId, EventUnignored, nz.Id, apiBy(r), nz.Title)
+	}
+	nz, _ = ws.st.Release(nz.Id)
+	apiJSON(w, http.StatusOK, toAPIRelease(nz))
+}
+
+//GET /downloads, ?client=sabnzbd for just one client's
+func (ws *WebServer) APIDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	ads := []apiDownload{}
+	for _, dl := range ws.st.Downloads(r.URL.Query().Get("client")) {
+		ads = append(ads, toAPIDownload(dl))
+	}
+	apiJSON(w, http.StatusOK, ads)
+}
+
+//POST /jobs/{job} - start one of the scheduled jobs now, it
+//carries on after we've answered. 409 if it's already running.
+func (ws *WebServer) APIJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["job"]
+	job, ok := Jobs[name]
+	if !ok {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("no job %q", name))
+		return
+	}
+	if !job.Start(ws.st, apiBy(r)) {
+		apiError(w, http.StatusConflict, fmt.Sprintf("%s is already running", name))
+		return
+	}
+	apiJSON(w, http.StatusAccepted, map[string]string{"job": name})
+}
+
+// the api routes on r, which is the /api/v1 subrouter
+func (ws *WebServer) APIRoutes(r *mux.Router) {
+	r.Use(ws.APIAuth)
+	r.HandleFunc("/movies", ws.APIMoviesHandler).Methods("GET")
+	r.HandleFunc("/movies", ws.APIAddMovieHandler).Methods("POST")
+	r.HandleFunc("/movies/{id:[0-9]+}", ws.APIMovieHandler).Methods("GET")
+	r.HandleFunc("/movies/{id:[0-9]+}", ws.APIRemoveMovieHandler).Methods("DELETE")
+	r.HandleFunc("/movies/{id:[0-9]+}/refresh", ws.APIRefreshHandler).Methods("POST")
+	r.HandleFunc("/movies/{id:[0-9]+}/releases", ws.APIReleasesHandler).Methods("GET")
+	r.HandleFunc("/movies/{id:[0-9]+}/releases/{guid}/grab", ws.APIGrabHandler).Methods("POST")
+	r.HandleFunc("/movies/{id:[0-9]+}/releases/{guid}/{action:ignore|unignore}", ws.APIIgnoreHandler).Methods("POST")
+	r.HandleFunc("/downloads", ws.APIDownloadsHandler).Methods("GET")
+	r.HandleFunc("/jobs/{job}", ws.APIJobHandler).Methods("POST")
+}
@@ -0,0 +1,75 @@
+//apistuff_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// the api routes, with the key testRequest sends
+func testAPI(t *testing.T, st Store) http.Handler {
+	saved := MYWEBAPIKEY
+	MYWEBAPIKEY = "testkey"
+	t.Cleanup(func() { MYWEBAPIKEY = saved })
+	ws := &WebServer{st: st}
+	r := mux.NewRouter()
+	ws.APIRoutes(r)
+	return r
+}
+
+func testRequest(h http.Handler, method string, url string) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, nil)
+	r.Header.Set("X-Api-Key", "testkey")
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAPIRemoveMovie(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		saved := MYCANCELREMOVED
+		MYCANCELREMOVED = true
+		defer func() { MYCANCELREMOVED = saved }()
+		tc := &testClient{}
+		DownloadClients = []DownloadClient{tc}
+		api := testAPI(t, st)
+		addMovie(t, st, 1, "Heat")
+		addRelease(t, st, testRelease(st, 1, "a", "Heat.1995.1080p.BluRay.x264-GRP", 10))
+		if !GrabAndMark(st, "a", 1, "test") {
+			t.Fatal("GrabAndMark(a) failed")
+		}
+
+		//still on the watchlist, so it can't be purged
+		if code := testRequest(api, "DELETE", "/movies/1?purge=1"); code != http.StatusBadRequest {
+			t.Errorf("purge of a watched movie = %d", code)
+		}
+		if _, err := st.Movie(1); err != nil || len(tc.removed) != 0 || len(st.Downloads("")) != 1 {
+			t.Errorf("purge refused but movie %v, removed %v", err, tc.removed)
+		}
+
+		//archived, and its download cancelled
+		if code := testRequest(api, "DELETE", "/movies/1"); code != http.StatusNoContent {
+			t.Errorf("archive = %d", code)
+		}
+		if mv, _ := st.Movie(1); mv.Archived.IsZero() || len(tc.removed) != 1 || len(st.Downloads("")) != 0 {
+			t.Errorf("archived %v, removed %v, downloads %+v", mv.Archived, tc.removed, st.Downloads(""))
+		}
+		if code := testRequest(api, "DELETE", "/movies/1"); code != http.StatusNoContent || len(tc.removed) != 1 {
+			t.Errorf("archive again = %d, removed %v", code, tc.removed)
+		}
+
+		if code := testRequest(api, "DELETE", "/movies/1?purge=1"); code != http.StatusNoContent {
+			t.Errorf("purge = %d", code)
+		}
+		if _, err := st.Movie(1); err != ErrNotFound {
+			t.Errorf("purged movie %v", err)
+		}
+		if code := testRequest(api, "DELETE", "/movies/1?purge=1"); code != http.StatusNotFound {
+			t.Errorf("purge again = %d", code)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+//backupstuff.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Timestamped copies of GoGoMovieDL.db made with sqlite's online
+// backup API, so they're consistent even while downloads are being
+// updated. Postgres has pg_dump for this.
+
+var (
+	MYBACKUPDIR      string //Where backups go
+	MYBACKUPINTERVAL int64  //Minutes between scheduled backups, 0 to never
+	MYBACKUPKEEP     int    //Newest backups to keep, 0 to keep them all
+)
+
+// backup file names, oldest sort first
+const BackupName = "GoGoMovieDL-20060102-150405.db"
+
+// a Store that can copy itself to a file
+type BackupStore interface {
+	Backup(path string) error
+}
+
+func (s *SQLStore) Backup(path string) error {
+	return BackupDB(s.db, path)
+}
+
+// where a backup taken now goes, never one we already have - a
+// restore backs up first and mustn't overwrite what it's restoring
+func BackupPath() string {
+	t := time.Now()
+	for {
+		path := filepath.Join(MYBACKUPDIR, t.Format(BackupName))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		t = t.Add(time.Second)
+	}
+}
+
+// back up to MYBACKUPDIR and drop the old ones, by is who asked
+func TakeBackup(st Store, by string) (path string, err error) {
+	bs, ok := st.(BackupStore)
+	if !ok {
+		return "", fmt.Errorf("nothing to back up with MYDBDSN %q", MYDBDSN)
+	}
+	path = BackupPath()
+	err = bs.Backup(path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("TakeBackup:Backed up to %s", path)
+	st.LogEvent(0, EventBackup, "", by, "Backed up to "+path)
+	PruneBackups(MYBACKUPDIR, MYBACKUPKEEP)
+	return path, nil
+}
+
+// copy the database to path, written alongside first and renamed
+// so a backup that fails part way never looks like a good one
+func BackupDB(d *DB, path string) error {
+	if d.Dialect != SQLite {
+		return fmt.Errorf("backups are for sqlite, use pg_dump for %s", d.Dialect)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	part := path + ".part"
+	os.Remove(part)
+	dest, err := sql.Open(string(SQLite), "file:"+part+"?mode=rwc")
+	if err != nil {
+		return err
+	}
+	err = copySQLite(dest, d.DB)
+	dest.Close()
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+// every backup in dir, oldest first
+func BackupList(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "GoGoMovieDL-*.db"))
+	if err != nil {
+		log.Println("BackupList:", err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// remove all but the newest keep backups
+func PruneBackups(dir string, keep int) {
+	files := BackupList(dir)
+	if keep <= 0 || len(files) <= keep {
+		return
+	}
+	for _, f := range files[:len(files)-keep] {
+		err := os.Remove(f)
+		if err != nil {
+			log.Println("PruneBackups:", err)
+			continue
+		}
+		log.Printf("PruneBackups:Removed %s", f)
+	}
+}
+
+// the schema version of a backup, an error if it's damaged, isn't
+// a GoGoMovieDL database or is from a newer GoGoMovieDL
+func CheckBackup(path string) (version int, err error) {
+	if _, err = os.Stat(path); err != nil {
+		return 0, err
+	}
+	bk, err := sql.Open(string(SQLite), "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer bk.Close()
+	var check string
+	err = bk.QueryRow("PRAGMA integrity_check").Scan(&check)
+	if err != nil {
+		return 0, fmt.Errorf("%s isn't a sqlite database: %v", path, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("%s is damaged: %s", path, check)
+	}
+	err = bk.QueryRow("select coalesce(max(version), 0) from schema_version").Scan(&version)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("%s has no schema version, it isn't a GoGoMovieDL backup", path)
+	}
+	if version > LatestSchemaVersion() {
+		return version, fmt.Errorf("%s is schema version %d but this GoGoMovieDL only knows up to %d, upgrade GoGoMovieDL", path, version, LatestSchemaVersion())
+	}
+	return version, nil
+}
+
+// GoGoMovieDL backup - back up the database now
+func BackupCommand() error {
+	if MYDBDSN == MemoryDSN {
+		return fmt.Errorf("MYDBDSN is %q, there's no database to back up", MYDBDSN)
+	}
+	err := OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	path, err := TakeBackup(NewSQLStore(db), "command")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up to %s\n", path)
+	return nil
+}
+
+// GoGoMovieDL restore file - replace the database with a backup,
+// keeping a backup of it as it was first. Stop GoGoMovieDL before
+// restoring, it would carry on with what it had cached. An older
+// backup is brought up to date next time GoGoMovieDL starts.
+func RestoreCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("restore needs a backup file, e.g. GoGoMovieDL restore " + filepath.Join(MYBACKUPDIR, BackupName))
+	}
+	from := args[0]
+	if MYDBDSN == MemoryDSN {
+		return fmt.Errorf("MYDBDSN is %q, there's no database to restore to", MYDBDSN)
+	}
+	version, err := CheckBackup(from)
+	if err != nil {
+		return err
+	}
+	err = OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	//not TakeBackup, pruning could remove the one we're restoring
+	saved := BackupPath()
+	err = BackupDB(db, saved)
+	if err != nil {
+		return fmt.Errorf("backing up the database before restoring: %v", err)
+	}
+	bk, err := sql.Open(string(SQLite), "file:"+from+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer bk.Close()
+	err = copySQLite(db.DB, bk)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %v, the database as it was is in %s", from, err, saved)
+	}
+	log.Printf("RestoreCommand:Restored %s at schema version %d, was backed up to %s", from, version, saved)
+	NewSQLStore(db).LogEvent(0, EventBackup, "", "command", "Restored "+from)
+	fmt.Printf("Restored %s at schema version %d, the database as it was is in %s\n", from, version, saved)
+	return nil
+}
+
+// the whole of src into dest with the online backup API, in one go
+// so it's a consistent copy
+func copySQLite(dest *sql.DB, src *sql.DB) error {
+	return rawSQLite(dest, func(dc *sqlite3.SQLiteConn) error {
+		return rawSQLite(src, func(sc *sqlite3.SQLiteConn) error {
+			bk, err := dc.Backup("main", sc, "main")
+			if err != nil {
+				return err
+			}
+			done, err := bk.Step(-1)
+			if err != nil {
+				bk.Finish()
+				return err
+			}
+			if !done {
+				bk.Finish()
+				return errors.New("backup didn't finish")
+			}
+			return bk.Finish()
+		})
+	})
+}
+
+// f on the driver's connection, which has the backup API
+func rawSQLite(d *sql.DB, f func(c *sqlite3.SQLiteConn) error) error {
+	conn, err := d.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc interface{}) error {
+		c, ok := dc.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("not a sqlite connection: %T", dc)
+		}
+		return f(c)
+	})
+}
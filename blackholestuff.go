@@ -0,0 +1,158 @@
+//blackholestuff.go
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Blackhole download client, writes .nzb files into a folder that
+// some other downloader watches, then looks for the finished download
+// in the completed folder. The nzb filename (without .nzb) is the dlid,
+// the nicename plus a bit of the guid so a grab never picks up what an
+// earlier grab with the same name left in the completed folder.
+type Blackhole struct {
+	ClientName   string
+	WatchDir     string
+	CompletedDir string
+}
+
+func NewBlackhole(name string, watchdir string, completeddir string) *Blackhole {
+	if name == "" {
+		name = "BLACKHOLE"
+	}
+	return &Blackhole{ClientName: name, WatchDir: watchdir, CompletedDir: completeddir}
+}
+
+func (bh *Blackhole) Name() string {
+	return bh.ClientName
+}
+
+func (bh *Blackhole) Protocol() string {
+	return "usenet"
+}
+
+// replace anything that can't go in a filename
+func SafeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
+
+// the dlid for a grab, nicename-1a2b3c4d
+func BlackholeName(guid string, nicename string) string {
+	sum := sha1.Sum([]byte(guid))
+	return fmt.Sprintf("%s-%x", SafeFilename(nicename), sum[:4])
+}
+
+//Fetch the nzb and write it to the watch folder as its dlid.nzb. Returns the dlid if valid
+func (bh *Blackhole) SendURL(guid string, nzblink string, nicename string) string {
+	name := BlackholeName(guid, nicename)
+	log.Printf("BlackholeSendURL:Grabbing:%s:%s:%s", guid, bh.WatchDir, name)
+	err := bh.WriteNZB(nzblink, filepath.Join(bh.WatchDir, name+".nzb"))
+	if err != nil {
+		log.Print("BlackholeSendURL:", err)
+		return ""
+	}
+	return name
+}
+
+// download to a temp file in the same folder and rename it into place,
+// so the watcher never sees half an nzb
+func (bh *Blackhole) WriteNZB(nzblink string, filename string) error {
+	r, err := http.Get(nzblink)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return errors.New("Couldn't get nzb: " + r.Status)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".gogomoviedl-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r.Body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	cerr := tmp.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// nzbs still in the watch folder are queued, anything in the completed
+// folder is done, unless the downloader has marked it _FAILED_
+func (bh *Blackhole) States() (map[string]DownloadState, error) {
+	states := make(map[string]DownloadState)
+
+	watched, err := ioutil.ReadDir(bh.WatchDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range watched {
+		if strings.HasSuffix(strings.ToLower(fi.Name()), ".nzb") {
+			states[fi.Name()[:len(fi.Name())-4]] = DownloadState{Status: DLStatusQueued}
+		}
+	}
+
+	completed, err := ioutil.ReadDir(bh.CompletedDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range completed {
+		name := fi.Name()
+		st := DownloadState{Status: DLStatusCompleted, Percentage: 100}
+		if strings.HasPrefix(name, "_FAILED_") {
+			name = strings.TrimPrefix(name, "_FAILED_")
+			st = DownloadState{Status: DLStatusFailed, Percentage: 100, Message: fi.Name()}
+		}
+		if !fi.IsDir() {
+			//a single file download keeps its extension
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		states[name] = st
+	}
+	return states, nil
+}
+
+// once the downloader picks up the nzb we can't see it
+// until it turns up in the completed folder
+func (bh *Blackhole) TracksAll() bool {
+	return false
+}
+
+// nothing to tidy up once complete, the completed folder belongs to
+// whatever sorts the downloads. Failed and stalled nzbs are taken out
+// of the watch folder
+func (bh *Blackhole) Remove(name string, status int) bool {
+	if status == DLStatusCompleted {
+		return true
+	}
+	err := os.Remove(filepath.Join(bh.WatchDir, name+".nzb"))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("BlackholeRemove:%s:%v", name, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+//blackholestuff_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// an indexer that hands out the same nzb for everything
+func testIndexer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><nzb xmlns="http://www.newzbin.com/DTD/2003/nzb"></nzb>`))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func testBlackhole(t *testing.T) *Blackhole {
+	bh := NewBlackhole("", filepath.Join(t.TempDir(), "watch"), filepath.Join(t.TempDir(), "completed"))
+	for _, dir := range []string{bh.WatchDir, bh.CompletedDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bh
+}
+
+// the downloader takes the nzb out of the watch folder, then leaves
+// what it got in the completed folder
+func (bh *Blackhole) testFinish(t *testing.T, dlid string, entry string) {
+	if err := os.Remove(filepath.Join(bh.WatchDir, dlid+".nzb")); err != nil {
+		t.Fatal(err)
+	}
+	if entry != "" {
+		if err := os.Mkdir(filepath.Join(bh.CompletedDir, entry), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlackholeName(t *testing.T) {
+	name := BlackholeName("https://indexer/details/1", `Heat: 1995 1080p/BluRay`)
+	if !regexp.MustCompile(`^Heat_ 1995 1080p_BluRay-[0-9a-f]{8}$`).MatchString(name) {
+		t.Errorf("BlackholeName = %q", name)
+	}
+	if name != BlackholeName("https://indexer/details/1", `Heat: 1995 1080p/BluRay`) {
+		t.Errorf("BlackholeName isn't the same for the same grab")
+	}
+	if name == BlackholeName("https://indexer/details/2", `Heat: 1995 1080p/BluRay`) {
+		t.Errorf("BlackholeName is the same for another guid")
+	}
+}
+
+func TestBlackholeRegrab(t *testing.T) {
+	ts := testIndexer(t)
+	tests := []struct {
+		name  string
+		entry string //what the earlier grab left in the completed folder
+		state int
+	}{
+		{"after a failure", "_FAILED_%s", DLStatusFailed},
+		{"after a completed copy", "%s", DLStatusCompleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bh := testBlackhole(t)
+			old := bh.SendURL("a", ts.URL, "Heat.1995.1080p.BluRay.x264-GRP")
+			if old == "" {
+				t.Fatal("SendURL(a) failed")
+			}
+			bh.testFinish(t, old, fmt.Sprintf(tt.entry, old))
+
+			dlid := bh.SendURL("b", ts.URL, "Heat.1995.1080p.BluRay.x264-GRP")
+			if dlid == "" || dlid == old {
+				t.Fatalf("SendURL(b) = %q, after %q", dlid, old)
+			}
+			states, err := bh.States()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if states[old].Status != tt.state || states[dlid].Status != DLStatusQueued {
+				t.Errorf("states %+v", states)
+			}
+
+			//picked up by the downloader, so out of sight, not done
+			bh.testFinish(t, dlid, "")
+			states, _ = bh.States()
+			if st, ok := states[dlid]; ok {
+				t.Errorf("new grab %s is %+v", dlid, st)
+			}
+		})
+	}
+}
+
+// copies of one release from different indexers usually have the same
+// title, a failed copy mustn't fail the next one sent to the blackhole
+func TestBlackholeSiblingFallback(t *testing.T) {
+	ts := testIndexer(t)
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		bh := testBlackhole(t)
+		DownloadClients = []DownloadClient{bh}
+		addMovie(t, st, 1, "Heat")
+		for i, guid := range []string{"a", "b", "c"} {
+			nz := testRelease(st, 1, guid, "Heat.1995.1080p.BluRay.x264-GRP", 10-float64(i)/100)
+			nz.Link, nz.Indexer = ts.URL+"/"+guid, guid
+			addRelease(t, st, nz)
+		}
+
+		if !GrabAndMark(st, "a", 1, "test") {
+			t.Fatal("GrabAndMark(a) failed")
+		}
+		dls := st.Downloads(bh.Name())
+		if len(dls) != 1 {
+			t.Fatalf("downloads %+v", dls)
+		}
+		bh.testFinish(t, dls[0].DlId, "_FAILED_"+dls[0].DlId)
+
+		//a fails, b goes in its place and stays queued
+		for i := 0; i < 2; i++ {
+			ParseDownloads(st)
+			dls = st.Downloads(bh.Name())
+			if len(dls) != 1 || dls[0].Guid != "b" {
+				t.Fatalf("pass %d: downloads %+v", i, dls)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(bh.WatchDir, dls[0].DlId+".nzb")); err != nil {
+			t.Error(err)
+		}
+		for guid, ignored := range map[string]int{"a": 1, "b": 0, "c": 0} {
+			if nz, _ := st.Release(guid); nz.Ignored != ignored {
+				t.Errorf("%s ignored %d, want %d", guid, nz.Ignored, ignored)
+			}
+		}
+		if nz, _ := st.Release("c"); nz.Grabbed != 0 {
+			t.Errorf("c was grabbed too")
+		}
+	})
+}
+
+// picked up by the downloader and never finished
+func TestBlackholeUnseen(t *testing.T) {
+	ts := testIndexer(t)
+	saved := MYSTALLTIMEOUT
+	MYSTALLTIMEOUT = 240
+	defer func() { MYSTALLTIMEOUT = saved }()
+
+	tests := []struct {
+		ago     time.Duration
+		timeout int64
+		unseen  bool
+	}{
+		{time.Hour, 240, false},
+		{5 * time.Hour, 240, true},
+		{5 * time.Hour, 0, false},
+	}
+	for _, tt := range tests {
+		MYSTALLTIMEOUT = tt.timeout
+		if unseen := DownloadUnseen(Downloads{Added: time.Now().Add(-tt.ago)}); unseen != tt.unseen {
+			t.Errorf("DownloadUnseen %v ago with timeout %d = %v", tt.ago, tt.timeout, unseen)
+		}
+	}
+
+	MYSTALLTIMEOUT = 240
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		bh := testBlackhole(t)
+		DownloadClients = []DownloadClient{bh}
+		addMovie(t, st, 1, "Heat")
+		nz := testRelease(st, 1, "a", "Heat.1995.1080p.BluRay.x264-GRP", 10)
+		nz.Link = ts.URL
+		addRelease(t, st, nz)
+		if !GrabAndMark(st, "a", 1, "test") {
+			t.Fatal("GrabAndMark(a) failed")
+		}
+		bh.testFinish(t, st.Downloads("")[0].DlId, "")
+
+		//still in time, so it's left alone
+		ParseDownloads(st)
+		if dls := st.Downloads(""); len(dls) != 1 {
+			t.Errorf("downloads %+v", dls)
+		}
+		if mv, _ := st.Movie(1); mv.Grabbed != 1 {
+			t.Errorf("movie no longer grabbed")
+		}
+
+		//out of time, failed and the movie's free for another grab
+		testAdded(t, st, "a", time.Now().Add(-5*time.Hour))
+		ParseDownloads(st)
+		if dls := st.Downloads(""); len(dls) != 0 {
+			t.Errorf("downloads %+v", dls)
+		}
+		if mv, _ := st.Movie(1); mv.Grabbed != 0 {
+			t.Errorf("movie still grabbed")
+		}
+		if nz, _ := st.Release("a"); nz.Ignored != 1 {
+			t.Errorf("unseen release not ignored")
+		}
+	})
+}
+
+// when a download was grabbed, there's no Store method to change it
+func testAdded(t *testing.T, st Store, guid string, added time.Time) {
+	switch st := st.(type) {
+	case *SQLStore:
+		if _, err := st.db.Exec("update downloads set added=? where guid=?", added, guid); err != nil {
+			t.Fatal(err)
+		}
+	case *MemoryStore:
+		dl := st.downloads[guid]
+		dl.Added = added
+		st.downloads[guid] = dl
+	}
+}
@@ -2,15 +2,13 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
-	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"database/sql"
-
-	"github.com/mattn/go-sqlite3"
 )
 
 type Movie struct {
@@ -22,28 +20,72 @@ type Movie struct {
 	NzbCount    int
 	IgnoreCount int
 	Orderfield  int
+	Profile     string
+	DlGuid      string //what was downloaded, blank if nothing yet
+	DlTitle     string
+	DlQuality   string
+	DlScore     float64
+	Runtime     int       //minutes, 0 if we don't know
+	Archived    time.Time //when it left the watchlist, zero if it's still on it
+	Manual      bool      //added through the api, the watchlist doesn't archive it
 }
 
-type NZB struct {
-	Id         string
+// a downloaded release that was replaced by a better one
+type Upgrade struct {
 	MovieId    int64
-	MovieName  string
-	Title      string
-	Link       string
-	Score      float64
-	Size       float64
-	Grabs      int
-	UsenetDate time.Time
-	Grabbed    int
-	Ignored    int
-	GrabURL    string
+	OldTitle   string
+	OldQuality string
+	OldScore   float64
+	NewTitle   string
+	NewQuality string
+	NewScore   float64
+	Upgraded   time.Time
+}
+
+type NZB struct {
+	Id          string
+	MovieId     int64
+	MovieName   string
+	Title       string
+	Link        string
+	Score       float64
+	Size        float64
+	Grabs       int
+	UsenetDate  time.Time
+	Grabbed     int
+	Ignored     int
+	GrabURL     string
+	Protocol    string
+	Indexer     string
+	Seeders     int
+	Peers       int
+	InfoHash    string
+	Magnet      string
+	Release     //parsed from Title
+	Quality     string
+	Rank        int //in the movie's quality profile, -1 if not allowed
+	ScoreDetail ScoreBreakdown
+	Runtime     int    //of the movie, for the expected size
+	Profile     string //of the movie
+	NormTitle   string //Title without the indexer's decoration, for duplicates
+	ReleaseId   string //guid of the first copy of this release, for duplicates
+	AutoIgnored bool   //ignored for scoring 0 or less, rescoring can take it back
+	Sources     []NZB  //other copies of the same release, for the movie page
 }
 
 type Downloads struct {
-	MovieID  int64
-	Nicename string
-	Guid     string
-	DlId     string
+	MovieID      int64
+	Nicename     string
+	Title        string
+	Guid         string
+	DlId         string
+	DlMethod     string
+	Percentage   int
+	Status       int
+	MBLeft       float64
+	TimeLeft     string
+	Added        time.Time
+	LastProgress time.Time
 }
 
 type Grabbable struct {
@@ -51,230 +93,453 @@ type Grabbable struct {
 	MovieTitle string
 	Id         string
 	Link       string
+	Quality    string
+	Score      float64
+	Rank       int //in the movie's quality profile, 0 is best
+	Upgrade    bool
+
+	Profile      string //of the movie
+	MovieGrabbed int    //of the movie, 1 if it's an upgrade candidate
+	DlQuality    string //of the movie's current download
 }
 
-func InitDB() (err error) {
+// The Store in the database, sqlite or postgres
+type SQLStore struct {
+	db *DB
+}
+
+func NewSQLStore(d *DB) *SQLStore {
+	return &SQLStore{db: d}
+}
 
-	db, err = sql.Open("sqlite3", "./GoGoMovieDL.db?mode=rwc&_busy_timeout=5000")
+// the Store MYDBDSN asks for, db is left nil for "memory"
+func InitStore() (Store, error) {
+	if MYDBDSN == MemoryDSN {
+		log.Println("InitStore:Keeping everything in memory")
+		return NewMemoryStore(), nil
+	}
+	st, err := InitDB()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// open the database and bring it up to date
+func InitDB() (st *SQLStore, err error) {
+	err = OpenDB()
+	if err != nil {
+		return nil, err
+	}
+
+	//create or update the tables, refuses a db from a newer version
+	from, to, err := MigrateDB()
+	if err != nil {
+		log.Println("InitDB:MigrateDB:", err)
+		return nil, err
+	}
+	if from != to {
+		log.Printf("InitDB:Migrated schema version %d to %d", from, to)
+	}
+	return NewSQLStore(db), nil
+}
+
+func OpenDB() (err error) {
+	dialect, dsn := DialectFor(MYDBDSN)
+	sqldb, err := sql.Open(string(dialect), dsn)
 	if err != nil {
 		log.Panic("Main:InitDB:", err)
 	}
+	db = &DB{sqldb, dialect}
+	return err
+}
 
-	sqlStmt := `
-
-	create table if not exists movies(
-		id integer not null primary key, 
-		title text,
-		coverurl text,
-		grabbed integer
-	);
-	
-	create table if not exists nzbs(
-		id text not null primary key, 
-		movieid integer not null,
-		title text,
-		link text,
-		score real,
-		size real,
-		grabs integer,
-		usenetdate date,
-		grabbed integer,
-		ignored integer
-	);
-	
-	create table if not exists downloads(
-		id int primary key,
-		guid text not null,
-		dlmethod text not null,
-		dlid text not null,
-		percentage int,
-		status int
-	);
-	`
-
-	_, err = db.Exec(sqlStmt)
-	if err != nil {
-		log.Println(err)
-		return err
+// every nzb, with what GetScore needs from its movie
+func (s *SQLStore) AllReleases() []NZB {
+	return s.releasesWhere("AllReleases", "1=1")
+}
+
+// releases from a group or an indexer, blank matches nothing
+func (s *SQLStore) ReleasesFrom(group string, indexer string) []NZB {
+	return s.releasesWhere("ReleasesFrom", "(n.releasegroup=? and n.releasegroup<>'') or (n.indexer=? and n.indexer<>'')", group, indexer)
+}
+
+// releases with their movie matching where, oldest first
+func (s *SQLStore) releasesWhere(caller string, where string, args ...interface{}) []NZB {
+	var nzbs []NZB
+
+	rows, err := s.db.Query(`select `+releaseColumns+` from nzbs n inner join movies m on m.id=n.movieid where `+where+` order by n.usenetdate`, args...)
+	if err != nil {
+		log.Println(caller+":Query", err)
+		return nil
 	}
-	return nil
+	defer rows.Close()
+	for rows.Next() {
+		nz, err := scanRelease(rows)
+		if err != nil {
+			log.Println(caller+":RowScan", err)
+		} else {
+			nzbs = append(nzbs, nz)
+		}
+	}
+	return nzbs
 }
 
-//scroll through the dataset and update the scores
-func UpdateNZBScores() {
-	var title string
-	var id string
-	var nzbsize float64
-	var usenetdate time.Time
-	var score float64
+// save the parsed release, quality and score of each nzb, ignoring
+// any that score 0 or less and taking that back once they score more
+// again. Ignored from the web or for failing stays ignored.
+// All or nothing, we can always try again.
+func (s *SQLStore) SetReleaseScores(nzbs []NZB) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// rolls back anything we didn't get to commit
+	defer tx.Rollback()
 
-	log.Println("UpdateNZBScores:Begin")
+	updatestmt, err := tx.Prepare("UPDATE nzbs SET score=?, scoredetail=?, ignored=case when autoignored=1 then 0 else ignored end, autoignored=0 WHERE id=?")
+	if err != nil {
+		return err
+	}
+	updateignorestmt, err := tx.Prepare("UPDATE nzbs SET score=?, scoredetail=?, ignored=1, autoignored=case when ignored=0 then 1 else autoignored end WHERE id=?")
+	if err != nil {
+		return err
+	}
+	releasestmt, err := tx.Prepare(`UPDATE nzbs SET year=?, resolution=?, source=?, codec=?, hdr=?, audio=?, channels=?,
+		edition=?, languages=?, proper=?, repack=?, releasegroup=?, quality=? WHERE id=?`)
+	if err != nil {
+		return err
+	}
+	for _, nz := range nzbs {
+		_, err := releasestmt.Exec(append(ReleaseValues(nz.Release), nz.Quality, nz.Id)...)
+		if err != nil {
+			return err
+		}
+		if nz.Score > 0 {
+			_, err = updatestmt.Exec(nz.Score, ScoreDetailJSON(nz.ScoreDetail), nz.Id)
+		} else {
+			_, err = updateignorestmt.Exec(nz.Score, ScoreDetailJSON(nz.ScoreDetail), nz.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
 
-	updatestmt, err := db.Prepare("UPDATE nzbs SET score=? WHERE id=?")
+// replace the config rules in the db, keeping the ones added from the web
+func (s *SQLStore) ReplaceConfigRules(rules []ScoreRule) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		log.Println("UpdateNZBScores:PrepareUpdateStmt", err)
-		return
+		return err
 	}
-	updateignorestmt, err := db.Prepare("UPDATE nzbs SET score=?, ignored=1 WHERE id=?")
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM scorerules WHERE fromconfig=1")
 	if err != nil {
-		log.Println("UpdateNZBScores:PrepareUpdateIgnoreStmt", err)
-		return
+		return err
 	}
-	rows, err := db.Query("select id,title,size,usenetdate from nzbs")
+	for _, sr := range rules {
+		_, err = tx.Exec("INSERT INTO scorerules(name, field, pattern, weight, reject, fromconfig) VALUES(?,?,?,?,?,1)",
+			sr.Name, sr.Field, sr.Pattern, sr.Weight, sr.Reject)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// every rule in the db, config ones first
+func (s *SQLStore) ScoreRules() []ScoreRule {
+	var sr ScoreRule
+	var rules []ScoreRule
+	rows, err := s.db.Query("select id, name, field, pattern, weight, reject, fromconfig from scorerules order by fromconfig desc, id")
 	if err != nil {
-		log.Println("UpdateNZBScores:Query", err)
-		return
+		log.Println("ScoreRules:Query", err)
+		return nil
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&id, &title, &nzbsize, &usenetdate)
+		err := rows.Scan(&sr.Id, &sr.Name, &sr.Field, &sr.Pattern, &sr.Weight, &sr.Reject, &sr.FromConfig)
 		if err != nil {
-			log.Println("UpdateNZBScores:RowScan", err)
+			log.Println("ScoreRules:RowScan", err)
 		} else {
-			score = GetScore(title, usenetdate, nzbsize)
-			// update record in db with new score, fail and return if error
-			// we can always try again later.
-			if score > 0 {
-				_, err := updatestmt.Exec(score, id)
-				if err != nil {
-					log.Println("UpdateNZBScores:UpdateAboveZeroScore", err)
-					return
-				}
-			} else {
-				_, err := updateignorestmt.Exec(score, id)
-				if err != nil {
-					log.Println("UpdateNZBScores:UpdateUnderZeroScore", err)
-					return
-				}
-			}
-
+			rules = append(rules, sr)
 		}
 	}
-	log.Println("UpdateNZBScores:End")
+	return rules
 }
 
-func UpdateCoverURL(id int64, coverurl string) {
-	_, err := db.Exec("UPDATE movies SET coverurl=? WHERE id=?", coverurl, id)
+func (s *SQLStore) AddScoreRule(sr ScoreRule) bool {
+	_, err := s.db.Exec("INSERT INTO scorerules(name, field, pattern, weight, reject, fromconfig) VALUES(?,?,?,?,?,0)",
+		sr.Name, sr.Field, sr.Pattern, sr.Weight, sr.Reject)
 	if err != nil {
-		log.Println("UpdateCoverURL:", err)
+		log.Println("AddScoreRule:", err)
+		return false
 	}
+	return true
 }
 
-// loop the NZBGRSS struct and
-// if the movie exists we add the
-// file to the releases table using
-// the returned id
-func NZBGRSStoDB(nz *NZBGRSS) (count int) {
-	var id int64
-	var grabs int
-	var ignoreval int
-	var size float64
-	var coverurl string
-	var guid string
-	var score float64
-	var usenetdate string
-	var usenetdt time.Time
-
-	stmt, err := db.Prepare("INSERT INTO nzbs(id, movieid, title, link, score, size, grabs, grabbed, ignored, usenetdate) VALUES(?,?,?,?,?,?,?,?,?,?)")
+// only web rules, config ones would just come back on restart
+func (s *SQLStore) DeleteScoreRule(id int64) bool {
+	_, err := s.db.Exec("DELETE FROM scorerules WHERE id=? AND fromconfig=0", id)
 	if err != nil {
-		log.Println("NZBGRSStoDB:PrepareStmt", err)
-		return 0
+		log.Println("DeleteScoreRule:", err)
+		return false
 	}
+	return true
+}
 
-	for _, mv := range nz.Channels.NZBGItems {
-
-		//reset inner loop vars
-		id = 0
-		usenetdate = ""
-		grabs = 0
-		size = 0
-		coverurl = ""
-		guid = ""
-
-		for _, nza := range mv.NZAttribs {
-
-			//log.Printf("%s = %s\n", nza.Name, nza.Value)
-
-			switch {
+// add a failure or a success for a group or indexer
+func (s *SQLStore) RecordSourceResult(table string, name string, completed bool) {
+	_, err := s.db.Exec("INSERT INTO "+table+"(name) VALUES(?) ON CONFLICT DO NOTHING", name)
+	if err != nil {
+		log.Printf("RecordSourceResult:%s:Insert:%v", table, err)
+		return
+	}
+	if completed {
+		_, err = s.db.Exec("UPDATE "+table+" SET completed=completed+1 WHERE name=?", name)
+	} else {
+		_, err = s.db.Exec("UPDATE "+table+" SET failed=failed+1, lastfailed=? WHERE name=?", time.Now(), name)
+	}
+	if err != nil {
+		log.Printf("RecordSourceResult:%s:Update:%v", table, err)
+	}
+}
 
-			case strings.ToLower(nza.Name) == "grabs":
-				grabs, _ = strconv.Atoi(nza.Value)
+// block, prefer or clear ("") a release group
+func (s *SQLStore) SetGroupPolicy(name string, policy string) {
+	_, err := s.db.Exec("INSERT INTO groupstats(name) VALUES(?) ON CONFLICT DO NOTHING", name)
+	if err == nil {
+		_, err = s.db.Exec("UPDATE groupstats SET policy=? WHERE name=?", policy, name)
+	}
+	if err != nil {
+		log.Printf("SetGroupPolicy:%s=%s:%v", name, policy, err)
+	}
+}
 
-			case strings.ToLower(nza.Name) == "size":
-				size, _ = strconv.ParseFloat(nza.Value, 64)
-				size = size / (1024 * 1024 * 1024)
+// forget the failures of a group or indexer
+func (s *SQLStore) ResetSourceStats(table string, name string) {
+	_, err := s.db.Exec("UPDATE "+table+" SET failed=0, completed=0, lastfailed=NULL WHERE name=?", name)
+	if err != nil {
+		log.Printf("ResetSourceStats:%s:%v", table, err)
+	}
+}
 
-			case strings.ToLower(nza.Name) == "coverurl":
-				coverurl = nza.Value
+// groupstats or indexerstats, worst first
+func (s *SQLStore) SourceStats(table string) []SourceStats {
+	var ss SourceStats
+	var lastfailed sql.NullTime
+	var list []SourceStats
+	rows, err := s.db.Query("select name, failed, completed, policy, lastfailed from " + table + " order by policy='' , failed-completed desc, name")
+	if err != nil {
+		log.Println("SourceStats:Query", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&ss.Name, &ss.Failed, &ss.Completed, &ss.Policy, &lastfailed)
+		if err != nil {
+			log.Println("SourceStats:RowScan", err)
+		} else {
+			ss.LastFailed = lastfailed.Time
+			list = append(list, ss)
+		}
+	}
+	return list
+}
 
-			case strings.ToLower(nza.Name) == "guid":
-				guid = nza.Value
+// record something that happened, for the timeline and History page.
+// Never fails anything, at worst it's missing from the history.
+func (s *SQLStore) LogEvent(movieid int64, kind string, guid string, by string, detail string) {
+	_, err := s.db.Exec("insert into events(happened,movieid,kind,guid,who,detail) values (?,?,?,?,?,?)",
+		time.Now(), movieid, kind, guid, by, detail)
+	if err != nil {
+		log.Printf("LogEvent:%d %s:%v", movieid, kind, err)
+	}
+}
 
-			case strings.ToLower(nza.Name) == "usenetdate":
-				usenetdate = nza.Value
+// events matching f, newest first
+func (s *SQLStore) Events(f EventFilter) []Event {
+	var (
+		ev     Event
+		evs    []Event
+		where  []string
+		params []interface{}
+	)
+	if f.MovieId != 0 {
+		where = append(where, "e.movieid=?")
+		params = append(params, f.MovieId)
+	}
+	if f.Kind != "" {
+		where = append(where, "e.kind=?")
+		params = append(params, f.Kind)
+	}
+	if f.By != "" {
+		where = append(where, "lower(e.who) like lower(?)")
+		params = append(params, f.By+"%")
+	}
+	if f.Search != "" {
+		where = append(where, "(lower(m.title) like lower(?) or lower(e.detail) like lower(?))")
+		params = append(params, "%"+f.Search+"%", "%"+f.Search+"%")
+	}
+	if f.Days > 0 {
+		where = append(where, "e.happened>=?")
+		params = append(params, time.Now().AddDate(0, 0, -f.Days))
+	}
+	query := `
+		select e.id,e.happened,e.movieid,coalesce(m.title,''),e.kind,e.guid,e.who,e.detail
+		from events e
+		left outer join movies m on m.id=e.movieid`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	if f.Limit <= 0 {
+		f.Limit = EventLimit
+	}
+	query += " order by e.happened desc, e.id desc limit ?"
+	params = append(params, f.Limit)
 
-			case strings.ToLower(nza.Name) == "imdb":
-				id, _ = strconv.ParseInt(nza.Value, 10, 64)
-			}
+	rows, err := s.db.Query(query, params...)
+	if err != nil {
+		log.Println("DB:Events:", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&ev.Id, &ev.When, &ev.MovieId, &ev.MovieTitle, &ev.Kind, &ev.Guid, &ev.By, &ev.Detail)
+		if err != nil {
+			log.Println("DB:Events:RowScan", err)
+			continue
 		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
 
-		//if we can find imdbid in our database then we add the file to the list, and calculate
-		//some kind of score based on text in like and hate lists
+func (s *SQLStore) SetMovieCover(id int64, coverurl string) {
+	_, err := s.db.Exec("UPDATE movies SET coverurl=? WHERE id=?", coverurl, id)
+	if err != nil {
+		log.Println("SetMovieCover:", err)
+	}
+}
 
-		if RSSIDExistsInDB(id) {
-			usenetdt, _ = time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", usenetdate)
-			score = GetScore(mv.Title, usenetdt, size)
-			if score > 0 {
-				ignoreval = 0
-			} else {
-				ignoreval = 1
-			}
-			_, err := stmt.Exec(guid, id, mv.Title, mv.Link, score, size, grabs, 0, ignoreval, usenetdt.Format(time.RFC3339))
-			if err != nil {
-				sqlerr := err.(sqlite3.Error)
-				if sqlerr.Code != sqlite3.ErrConstraint {
-					log.Printf("NZBGRSStoDB:ExecInsert:%d %s", sqlerr.Code, sqlerr.Error())
-				}
-			} else {
-				UpdateCoverURL(id, coverurl)
-				log.Printf("Found NZB id %s for %d %s with score %.0f", guid, id, mv.Title, score)
-				count += 1
-			}
+// add an nzb, false if we already have it
+func (s *SQLStore) AddRelease(nz NZB) (added bool, err error) {
+	ignoreval := 1
+	if nz.Score > 0 {
+		ignoreval = 0
+	}
+	//a reject rule can be deleted later, the rescore takes the ignore back
+	_, err = s.db.Exec(`INSERT INTO nzbs(id, movieid, title, link, score, size, grabs, grabbed, ignored, autoignored, usenetdate, protocol, indexer, seeders, peers, infohash, magnet,
+		year, resolution, source, codec, hdr, audio, channels, edition, languages, proper, repack, releasegroup, quality, scoredetail, normtitle, releaseid)
+		VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		append([]interface{}{nz.Id, nz.MovieId, nz.Title, nz.Link, nz.Score, nz.Size, nz.Grabs, 0, ignoreval, ignoreval, nz.UsenetDate.Format(time.RFC3339),
+			nz.Protocol, nz.Indexer, nz.Seeders, nz.Peers, nz.InfoHash, nz.Magnet}, append(ReleaseValues(nz.Release), nz.Quality, ScoreDetailJSON(nz.ScoreDetail), nz.NormTitle, nz.ReleaseId)...)...)
+	if err != nil {
+		if IsConstraintErr(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
 
+// copies of a release we've already grouped for a movie, oldest first
+func (s *SQLStore) ReleasesNamed(movieid int64, normtitle string) []NZB {
+	var nz NZB
+	var nzbs []NZB
+	rows, err := s.db.Query("select id, releaseid, size from nzbs where movieid=? and normtitle=? and releaseid<>'' order by usenetdate", movieid, normtitle)
+	if err != nil {
+		log.Println("ReleasesNamed:Query", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&nz.Id, &nz.ReleaseId, &nz.Size)
+		if err != nil {
+			log.Println("ReleasesNamed:RowScan", err)
+			continue
 		}
+		nzbs = append(nzbs, nz)
+	}
+	return nzbs
+}
 
+// nzbs from before duplicates were tracked, oldest first
+func (s *SQLStore) UngroupedReleases() []NZB {
+	var nz NZB
+	var nzbs []NZB
+	rows, err := s.db.Query("select id, movieid, title, size from nzbs where releaseid='' order by usenetdate")
+	if err != nil {
+		log.Println("UngroupedReleases:Query", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&nz.Id, &nz.MovieId, &nz.Title, &nz.Size)
+		if err != nil {
+			log.Println("UngroupedReleases:RowScan", err)
+		} else {
+			nzbs = append(nzbs, nz)
+		}
 	}
-	return count
+	return nzbs
 }
 
-//returns count of comma separated words in instring
-func WordsInString(words string, instring string) (count int) {
-	splitwords := strings.Split(words, ",")
-	for _, word := range splitwords {
-		if strings.Contains(strings.ToLower(instring), strings.ToLower(word)) {
-			count += 1
+func (s *SQLStore) SetReleaseId(guid string, normtitle string, releaseid string) error {
+	_, err := s.db.Exec("UPDATE nzbs SET normtitle=?, releaseid=? WHERE id=?", normtitle, releaseid, guid)
+	return err
+}
+
+// other copies of the same release that could still be grabbed, best first
+func (s *SQLStore) SiblingSources(guid string) (guids []string) {
+	var sibling string
+	rows, err := s.db.Query(`select s.id from nzbs n
+		inner join nzbs s on s.movieid=n.movieid and s.releaseid=n.releaseid and s.id<>n.id
+		inner join movies m on m.id=n.movieid
+		where n.id=? and n.releaseid<>'' and m.archived is null and s.grabbed=0 and s.ignored=0 and s.score>0
+		order by s.score desc`, guid)
+	if err != nil {
+		log.Println("SiblingSources:Query", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&sibling)
+		if err != nil {
+			log.Println("SiblingSources:RowScan", err)
+			continue
 		}
+		guids = append(guids, sibling)
 	}
-	return count
+	return guids
 }
 
-// check if id exists in db and return true
-// or return false if id is zero or doesn't exist
-func RSSIDExistsInDB(id int64) bool {
-	var title string
-	if id == 0 {
-		return false
+// parsed release fields in column order, year through releasegroup
+func ReleaseValues(r Release) []interface{} {
+	return []interface{}{r.Year, r.Resolution, r.Source, r.Codec, r.HDR, r.Audio, r.Channels,
+		r.Edition, r.Languages, r.Proper, r.Repack, r.Group}
+}
+
+// score breakdown as stored in nzbs.scoredetail
+func ScoreDetailJSON(sb ScoreBreakdown) string {
+	b, err := json.Marshal(sb)
+	if err != nil {
+		log.Println("ScoreDetailJSON:", err)
+		return ""
 	}
-	err := db.QueryRow("SELECT title FROM movies where id=?", id).Scan(&title)
-	switch {
-	case err == sql.ErrNoRows:
-		return false
-	case err != nil:
-		log.Println("RSSIDExistsInDB:", err)
-		return false
-	default:
-		return true
+	return string(b)
+}
+
+// blank for nzbs scored before we kept the detail
+func ScoreDetailFromJSON(detail string) (sb ScoreBreakdown) {
+	if detail != "" {
+		err := json.Unmarshal([]byte(detail), &sb)
+		if err != nil {
+			log.Println("ScoreDetailFromJSON:", err)
+		}
 	}
+	return sb
 }
 
 func TTtoID(tturl string) (id int64, err error) {
@@ -291,235 +556,435 @@ func TTtoID(tturl string) (id int64, err error) {
 	return id, nil
 }
 
-// delete items from db if doesn't exist in rs
-func DBRemoveMissingRSS2(rs *RSS2) (count int) {
-	//make map from rs
-	rsItems := make(map[int64]bool)
-	for _, mv := range rs.Items {
-		id, err := TTtoID(mv.Link)
-		if err != nil {
-			//do nothing
-		} else {
-			rsItems[id] = true
-		}
+// every nzbs column and the movie's title, runtime and profile in the
+// order scanRelease wants them, from nzbs n joined to movies m
+const releaseColumns = `n.id,n.movieid,n.title,n.link,n.score,n.size,coalesce(n.grabs,0),n.usenetdate,n.grabbed,n.ignored
+	,n.protocol,n.indexer,n.seeders,n.peers,n.infohash,n.magnet
+	,n.year,n.resolution,n.source,n.codec,n.hdr,n.audio,n.channels,n.edition,n.languages,n.proper,n.repack,n.releasegroup
+	,n.quality,n.scoredetail,n.normtitle,n.releaseid,n.autoignored,m.title,m.runtime,m.profile`
+
+// a row of releaseColumns, row is a *sql.Row or *sql.Rows
+func scanRelease(row interface {
+	Scan(dest ...interface{}) error
+}) (nz NZB, err error) {
+	var scoredetail string
+	err = row.Scan(&nz.Id, &nz.MovieId, &nz.Title, &nz.Link, &nz.Score, &nz.Size, &nz.Grabs, &nz.UsenetDate, &nz.Grabbed, &nz.Ignored,
+		&nz.Protocol, &nz.Indexer, &nz.Seeders, &nz.Peers, &nz.InfoHash, &nz.Magnet,
+		&nz.Year, &nz.Resolution, &nz.Source, &nz.Codec, &nz.HDR, &nz.Audio, &nz.Channels, &nz.Edition, &nz.Languages, &nz.Proper, &nz.Repack, &nz.Group,
+		&nz.Quality, &scoredetail, &nz.NormTitle, &nz.ReleaseId, &nz.AutoIgnored, &nz.MovieName, &nz.Runtime, &nz.Profile)
+	nz.ScoreDetail = ScoreDetailFromJSON(scoredetail)
+	return nz, err
+}
+
+// one nzb, ErrNotFound if we haven't got it
+func (s *SQLStore) Release(guid string) (nz NZB, err error) {
+	nz, err = scanRelease(s.db.QueryRow(`
+		select `+releaseColumns+`
+		from nzbs n
+		inner join movies m on m.id=n.movieid
+		where n.id=?
+	`, guid))
+	if err == sql.ErrNoRows {
+		return nz, ErrNotFound
 	}
+	if err != nil {
+		log.Println("Release:", err)
+		return nz, err
+	}
+	return nz, nil
+}
 
-	if len(rsItems) > 0 {
-		//get list of movies from db
-		movs := MoviesList(0)
-		for _, mov := range movs {
-			//is movie id in map?
-			_, ok := rsItems[mov.Id]
-			if !ok {
-				if DeleteMovieFromDB(mov.Id) {
-					log.Println("Movie not in watchlist, removed %d : %s", mov.Id, mov.Title)
-					count = +1
-				}
-			}
-		}
+func (s *SQLStore) SetReleaseSeeders(guid string, seeders int, peers int) {
+	_, err := s.db.Exec("UPDATE nzbs SET seeders=?, peers=? WHERE id=?", seeders, peers, guid)
+	if err != nil {
+		log.Println("SetReleaseSeeders:", err)
+	}
+}
+
+// one movie, archived or not, ErrNotFound if we haven't got it
+func (s *SQLStore) Movie(id int64) (mv Movie, err error) {
+	var archived sql.NullTime
+	err = s.db.QueryRow(`select id,title,coalesce(coverurl,''),grabbed,profile,dlguid,dltitle,dlquality,dlscore,runtime,archived,manual
+		from movies where id=?`, id).Scan(&mv.Id, &mv.Title, &mv.CoverUrl, &mv.Grabbed, &mv.Profile, &mv.DlGuid, &mv.DlTitle, &mv.DlQuality, &mv.DlScore, &mv.Runtime, &archived, &mv.Manual)
+	if err == sql.ErrNoRows {
+		return mv, ErrNotFound
 	}
-	return count
+	if err != nil {
+		log.Println("Movie:", err)
+		return mv, err
+	}
+	mv.Archived = archived.Time
+	return mv, nil
 }
 
-func DeleteMovieFromDB(movieid int64) bool {
-	_, err := db.Exec("delete from movies where id=?", movieid)
+// take a movie off the list but keep its history, it can be restored.
+// True if it was on the list.
+func (s *SQLStore) ArchiveMovie(movieid int64) bool {
+	res, err := s.db.Exec("update movies set archived=? where id=? and archived is null", time.Now(), movieid)
 	if err != nil {
+		log.Println("ArchiveMovie:", err)
 		return false
-	} else {
-		return true
 	}
+	n, _ := res.RowsAffected()
+	return n == 1
 }
 
-// loop the imdb rss and if the
-// movie doesn't already exist we add to
-// the Movies table
-func RSS2toDB(rs *RSS2) (count int) {
-	stmt, err := db.Prepare("INSERT INTO movies(id, title, grabbed) VALUES(?,?,?)")
+// back on the list, true if it was archived
+func (s *SQLStore) RestoreMovie(movieid int64) bool {
+	res, err := s.db.Exec("update movies set archived=null where id=? and archived is not null", movieid)
 	if err != nil {
-		log.Println("RSS2DB:PrepareStmt", err)
-		return 0
+		log.Println("RestoreMovie:", err)
+		return false
 	}
-	defer stmt.Close()
+	n, _ := res.RowsAffected()
+	return n > 0
+}
 
-	for _, mv := range rs.Items {
-		//Get ID as INT64
-		id, err := TTtoID(mv.Link)
+// archived movies, most recently archived first
+func (s *SQLStore) ArchivedMovies() []Movie {
+	var (
+		mv  Movie
+		mvs []Movie
+	)
+	rows, err := s.db.Query(`
+		select id,title,grabbed,coalesce(coverurl,''),coalesce(dltitle,''),archived,coalesce(nzbcount,0)
+		from movies
+		left outer join (select movieid,count(id) as nzbcount from nzbs group by movieid) as c on c.movieid=id
+		where archived is not null
+		order by archived desc
+	`)
+	if err != nil {
+		log.Println("DB:ArchivedMovies:", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&mv.Id, &mv.Title, &mv.Grabbed, &mv.CoverUrl, &mv.DlTitle, &mv.Archived, &mv.NzbCount)
 		if err != nil {
-			log.Printf("Couldn't find ID - %s %s %+v", mv.Title, mv.Link, err)
+			log.Println("DB:ArchivedMovies:RowScan", err)
+			continue
 		}
+		mvs = append(mvs, mv)
+	}
+	return mvs
+}
 
-		_, err = stmt.Exec(id, mv.Title, 0)
-		if err != nil {
-			//if err then cast to sqlite3.Error so we can ignore specific errors
-			sqlerr := err.(sqlite3.Error)
-			if sqlerr.Code != sqlite3.ErrConstraint {
-				log.Printf("RSS2DB:ExecInsert:%d %s", sqlerr.Code, sqlerr.Error())
-			}
-		} else {
-			log.Printf("RSS2DB:Added Movie %s with ID:%d", mv.Title, id)
-			count += 1
-		}
+// delete a movie, its nzbs, downloads and upgrades go with it
+func (s *SQLStore) DeleteMovie(movieid int64) bool {
+	_, err := s.db.Exec("delete from movies where id=?", movieid)
+	if err != nil {
+		log.Println("DeleteMovie:", err)
+		return false
+	}
+	return true
+}
 
+// add a movie from the watchlist, false if we already have it
+func (s *SQLStore) AddMovie(id int64, title string, runtime int) (added bool, err error) {
+	_, err = s.db.Exec("INSERT INTO movies(id, title, grabbed, runtime) VALUES(?,?,?,?)", id, title, 0, runtime)
+	if err != nil {
+		if IsConstraintErr(err) {
+			return false, nil
+		}
+		return false, err
 	}
-	return count
+	return true, nil
 }
 
-func NzbListByMovie(MovieId int64, GrabbedStatus int, IgnoredStatus int) []NZB {
-	var (
-		mv  NZB
-		mvs []NZB
-	)
-	rows, err := db.Query(`
-		select n.id,n.movieid,m.title as moviename,n.title,link,score,size,grabs
-		,usenetdate,n.grabbed,ignored 
+// every nzb for a movie, ignored ones last
+func (s *SQLStore) Releases(MovieId int64) []NZB {
+	var mvs []NZB
+	rows, err := s.db.Query(`
+		select `+releaseColumns+`
 		from nzbs n
 		inner join movies m on m.id=n.movieid
 		where n.movieid=?
-		order by ignored,score desc	
+		order by n.ignored,n.score desc
 	`, MovieId)
 	if err != nil {
-		log.Println("DB:NzbListByMovie:", err)
+		log.Println("DB:Releases:", err)
 		return nil
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&mv.Id, &mv.MovieId, &mv.MovieName, &mv.Title, &mv.Link, &mv.Score, &mv.Size, &mv.Grabs, &mv.UsenetDate, &mv.Grabbed, &mv.Ignored)
+		mv, err := scanRelease(rows)
+		if err != nil {
+			log.Println("DB:Releases:RowScan", err)
+			continue
+		}
 		mvs = append(mvs, mv)
 	}
 
 	return mvs
 }
 
-func MoviesList(GrabbedStatus int) []Movie {
+// movies on the watchlist, the ones with nzbs to grab first
+func (s *SQLStore) Movies() []Movie {
 	var (
 		mv  Movie
 		mvs []Movie
 	)
 
-	rows, err := db.Query(`
-		PRAGMA read_uncommitted = 1;
-		select id,title,grabbed,coalesce(nzbcount,0) as nzbcount,coalesce(ignorecount,0) as ignorecount,coalesce(coverurl,'') as coverurl, case when (1-grabbed)*(nzbcount-ignorecount)>0 THEN 0 ELSE 1 END AS orderfield 
-		from movies 
+	rows, err := s.db.Query(`
+		select id,title,grabbed,coalesce(nzbcount,0) as nzbcount,coalesce(ignorecount,0) as ignorecount,coalesce(coverurl,'') as coverurl, case when (1-grabbed)*(nzbcount-ignorecount)>0 THEN 0 ELSE 1 END AS orderfield,manual
+		from movies
 		left outer join (select movieid,count(id) as nzbcount,sum(ignored) as ignorecount from nzbs group by movieid) as c on c.movieid=id
-		order by Orderfield,grabbed,title	
+		where archived is null
+		order by Orderfield,grabbed,title
 	`)
 	if err != nil {
-		log.Println("DB:MoviesList:", err)
+		log.Println("DB:Movies:", err)
 		return nil
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&mv.Id, &mv.Title, &mv.Grabbed, &mv.NzbCount, &mv.IgnoreCount, &mv.CoverUrl, &mv.Orderfield)
+		err = rows.Scan(&mv.Id, &mv.Title, &mv.Grabbed, &mv.NzbCount, &mv.IgnoreCount, &mv.CoverUrl, &mv.Orderfield, &mv.Manual)
 		mvs = append(mvs, mv)
 	}
 
 	return mvs
 }
 
-func DownloadList(dlmethod string) []Downloads {
+// movies to search the indexers for - ungrabbed ones, and with
+// upgrades on downloaded ones too
+func (s *SQLStore) WantedMovies(upgrades bool) []Movie {
+	var (
+		mv  Movie
+		mvs []Movie
+	)
+	rows, err := s.db.Query(`
+		select distinct id,title,grabbed,profile,dlquality from movies where (grabbed=0 or (dlguid<>'' and ?=1)) and archived is null
+	`, upgrades)
+	if err != nil {
+		log.Println("DB:WantedMovies:", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&mv.Id, &mv.Title, &mv.Grabbed, &mv.Profile, &mv.DlQuality)
+		if err != nil {
+			log.Println("DB:WantedMovies:RowScan", err)
+			continue
+		}
+		mvs = append(mvs, mv)
+	}
+	return mvs
+}
+
+// downloads for one download client, or all of them if dlmethod is blank
+func (s *SQLStore) Downloads(dlmethod string) []Downloads {
 	var (
-		dl  Downloads
-		dls []Downloads
+		dl           Downloads
+		dls          []Downloads
+		added        sql.NullTime
+		lastprogress sql.NullTime
 	)
 
-	rows, err := db.Query(`
-		select m.title as nicename,movieid,guid,dlid from downloads d inner join nzbs n on d.guid=n.id inner join movies m on m.id=n.movieid where dlmethod=?
-	`, dlmethod)
+	rows, err := s.db.Query(`
+		select m.title as nicename,n.title,movieid,guid,dlmethod,dlid,coalesce(percentage,0),coalesce(status,0)
+		,coalesce(mbleft,0),coalesce(timeleft,''),added,lastprogress
+		from downloads d inner join nzbs n on d.guid=n.id inner join movies m on m.id=n.movieid
+		where dlmethod=? or ?=''
+		order by added
+	`, dlmethod, dlmethod)
 	if err != nil {
-		log.Println("DB:DownloadList:", err)
+		log.Println("DB:Downloads:", err)
 		return nil
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&dl.Nicename, &dl.MovieID, &dl.Guid, &dl.DlId)
+		err = rows.Scan(&dl.Nicename, &dl.Title, &dl.MovieID, &dl.Guid, &dl.DlMethod, &dl.DlId, &dl.Percentage, &dl.Status, &dl.MBLeft, &dl.TimeLeft, &added, &lastprogress)
+		//downloads from before we kept the time count as old
+		dl.Added = added.Time
+		dl.LastProgress = lastprogress.Time
 		dls = append(dls, dl)
 	}
 
 	return dls
 }
 
-func GrabbableList() []Grabbable {
+// ungrabbed nzbs worth grabbing for movies on the watchlist, using
+// protocols we have a download client for, by movie and best score
+// first. With upgrades, nzbs for downloaded movies that aren't
+// downloading anything else too - GrabbableList decides.
+func (s *SQLStore) GrabCandidates(protocols []string, upgrades bool) []Grabbable {
 	var (
 		gb  Grabbable
 		gbs []Grabbable
 	)
-	rows, err := db.Query(`
-		select n.movieid,m.title as movietitle, n.id, n.link from nzbs n 
-		inner join (select movieid,max(score) as maxscore from nzbs 
-		where score>0 and grabbed=0 and ignored=0 
-		group by movieid) as c on c.movieid=n.movieid and c.maxscore=n.score
+	if len(protocols) == 0 {
+		return nil
+	}
+	args := []interface{}{upgrades}
+	for _, p := range protocols {
+		args = append(args, p)
+	}
+	rows, err := s.db.Query(`
+		select n.movieid,m.title as movietitle, n.id, n.link, n.quality, n.score, m.profile, m.grabbed, m.dlquality from nzbs n
 		inner join movies m on m.id=n.movieid
-		where m.grabbed=0
-	`)
+		where m.archived is null and (m.grabbed=0 or (m.dlguid<>'' and ?=1 and not exists (
+			select 1 from downloads d inner join nzbs x on x.id=d.guid where x.movieid=m.id)))
+		and n.score>0 and n.grabbed=0 and n.ignored=0 and n.protocol in (?`+strings.Repeat(",?", len(protocols)-1)+`)
+		order by n.movieid, n.score desc
+	`, args...)
 	if err != nil {
-		log.Println("DB:GrabbableList:", err)
+		log.Println("DB:GrabCandidates:", err)
 		return nil
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&gb.MovieId, &gb.MovieTitle, &gb.Id, &gb.Link)
+		err = rows.Scan(&gb.MovieId, &gb.MovieTitle, &gb.Id, &gb.Link, &gb.Quality, &gb.Score, &gb.Profile, &gb.MovieGrabbed, &gb.DlQuality)
+		if err != nil {
+			log.Println("DB:GrabCandidates:RowScan", err)
+			continue
+		}
 		gbs = append(gbs, gb)
 	}
 	return gbs
 }
 
-//Calculate score from nzb title, date and size
-func GetScore(title string, usenetdate time.Time, nzbsize float64) (score float64) {
-	if nzbsize > 0.7 {
-		nzbage := int(time.Since(usenetdate).Hours() / 24)
-		// calculate score - gaussian distribution on size and exponential decay for age
-		gauss := 100 - math.Abs(math.Pow(nzbsize-4.5, 2)/(2*math.Pow(1.5, 2)))
-		decay := math.Pow(math.E, (-1*float64(nzbage))/180) * 100
-		// score is a combination of both - this means that slightly older files
-		// that are closer to 7.5Gb will have a slightly higher score than newer files
-		// that deviate away from this size.
+func (s *SQLStore) SetReleaseFlags(guid string, grabflag int, ignoreflag int) {
+	//someone decided, so rescoring leaves it alone
+	_, err := s.db.Exec("update nzbs set grabbed=?, ignored=?, autoignored=0 where id=?", grabflag, ignoreflag, guid)
+	if err != nil {
+		log.Printf("SetReleaseFlags:Grab=%d,Ignore=%d,GUID=%s:%v", grabflag, ignoreflag, guid, err)
+	}
+}
 
-		// Preferred words get a bonus of 500, and banned words a bonus of -10000
-		score = gauss*decay + (float64(WordsInString(MYPREFERREDWORDS, title)) * 500) - (float64(WordsInString(MYBANNEDWORDS, title)) * 10000)
-	} else {
-		score = -10000.7
+// true if the runtime changed, so the scores need redoing
+func (s *SQLStore) SetMovieRuntime(id int64, runtime int) bool {
+	res, err := s.db.Exec("UPDATE movies SET runtime=? WHERE id=? AND runtime<>?", runtime, id, runtime)
+	if err != nil {
+		log.Printf("SetMovieRuntime:Runtime=%d,Id=%d:%v", runtime, id, err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("SetMovieRuntime:%d now %d mins", id, runtime)
+		return true
+	}
+	return false
+}
+
+// manual movies stay when they're not on the watchlist
+func (s *SQLStore) SetMovieManual(id int64, manual bool) {
+	_, err := s.db.Exec("update movies set manual=? where id=?", manual, id)
+	if err != nil {
+		log.Printf("SetMovieManual:Manual=%v,Id=%d:%v", manual, id, err)
+	}
+}
+
+func (s *SQLStore) SetMovieProfile(id int64, profile string) {
+	_, err := s.db.Exec("update movies set profile=? where id=?", profile, id)
+	if err != nil {
+		log.Printf("SetMovieProfile:Profile=%s,Id=%d:%v", profile, id, err)
+	}
+}
+
+// after a failed download the movie goes back to grabbed if
+// we already have an earlier download of it, otherwise ungrabbed
+func (s *SQLStore) RevertMovieGrab(id int64) {
+	_, err := s.db.Exec("update movies set grabbed=case when dlguid<>'' then 1 else 0 end where id=?", id)
+	if err != nil {
+		log.Printf("RevertMovieGrab:Id=%d:%v", id, err)
+	}
+}
+
+// remember what was downloaded for a movie, and if it replaces
+// an earlier download keep a record of the upgrade
+func (s *SQLStore) SetMovieDownloaded(id int64, guid string) (up Upgrade, upgraded bool) {
+	var mv Movie
+	var nz NZB
+	err := s.db.QueryRow("select dlguid,dltitle,dlquality,dlscore from movies where id=?", id).Scan(&mv.DlGuid, &mv.DlTitle, &mv.DlQuality, &mv.DlScore)
+	if err != nil {
+		log.Printf("SetMovieDownloaded:Movie:%d:%v", id, err)
+		return up, false
+	}
+	err = s.db.QueryRow("select title,quality,score from nzbs where id=?", guid).Scan(&nz.Title, &nz.Quality, &nz.Score)
+	if err != nil {
+		log.Printf("SetMovieDownloaded:NZB:%s:%v", guid, err)
+		return up, false
+	}
+	_, err = s.db.Exec("update movies set dlguid=?, dltitle=?, dlquality=?, dlscore=? where id=?", guid, nz.Title, nz.Quality, nz.Score, id)
+	if err != nil {
+		log.Printf("SetMovieDownloaded:Update:%d:%v", id, err)
+		return up, false
 	}
-	return score
+	if mv.DlGuid == "" || mv.DlGuid == guid {
+		return up, false
+	}
+	up = Upgrade{id, mv.DlTitle, mv.DlQuality, mv.DlScore, nz.Title, nz.Quality, nz.Score, time.Now()}
+	_, err = s.db.Exec(`insert into upgrades(movieid,oldguid,oldtitle,oldquality,oldscore,newguid,newtitle,newquality,newscore,upgraded)
+		values (?,?,?,?,?,?,?,?,?,?)`, id, mv.DlGuid, up.OldTitle, up.OldQuality, up.OldScore, guid, up.NewTitle, up.NewQuality, up.NewScore, up.Upgraded)
+	if err != nil {
+		log.Printf("SetMovieDownloaded:Upgrade:%d:%v", id, err)
+	}
+	return up, true
 }
 
-// Get URL and Title from DB
-func URLAndTitleFromDB(guid string, id int64) (nzburl string, nicename string) {
-	err := db.QueryRow("select title,n.link from nzbs n where n.id=? and movieid=?", guid, id).Scan(&nicename, &nzburl)
+// forget what was downloaded, e.g. when it's been marked ungrabbed
+func (s *SQLStore) ClearMovieDownloaded(id int64) {
+	_, err := s.db.Exec("update movies set dlguid='', dltitle='', dlquality='', dlscore=0 where id=?", id)
 	if err != nil {
-		log.Print(err)
-		return "", ""
+		log.Printf("ClearMovieDownloaded:Id=%d:%v", id, err)
 	}
-	return nzburl, nicename
 }
 
-func SetNZBGrabIgnore(guid string, grabflag int, ignoreflag int) {
-	_, err := db.Exec("update nzbs set grabbed=?, ignored=? where id=?", grabflag, ignoreflag, guid)
+// upgrade history for a movie, newest first
+func (s *SQLStore) Upgrades(id int64) []Upgrade {
+	var (
+		up  Upgrade
+		ups []Upgrade
+	)
+	rows, err := s.db.Query(`
+		select movieid,oldtitle,oldquality,oldscore,newtitle,newquality,newscore,upgraded
+		from upgrades where movieid=? order by upgraded desc
+	`, id)
 	if err != nil {
-		log.Printf("SetNZBGrabIgnore:Grab=%d,Ignore=%d:%v", grabflag, ignoreflag, err)
+		log.Println("DB:Upgrades:", err)
+		return nil
 	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&up.MovieId, &up.OldTitle, &up.OldQuality, &up.OldScore, &up.NewTitle, &up.NewQuality, &up.NewScore, &up.Upgraded)
+		ups = append(ups, up)
+	}
+	return ups
 }
 
-func SetMovieGrab(id int64, grabflag int) {
-	_, err := db.Exec("update movies set grabbed=? where id=?", grabflag, id)
+func (s *SQLStore) SetMovieGrab(id int64, grabflag int) {
+	_, err := s.db.Exec("update movies set grabbed=? where id=?", grabflag, id)
 	if err != nil {
 		log.Printf("SetMovieGrab:Grab=%d,Id=%d:%v", grabflag, id, err)
 	}
 }
 
-func MarkNZBDownload(Nzo_id string, guid string, Method string) {
-	_, err := db.Exec("INSERT into downloads (guid,dlmethod,dlid) values (?,?,?)", guid, Method, Nzo_id)
+func (s *SQLStore) AddDownload(Nzo_id string, guid string, Method string) {
+	_, err := s.db.Exec("INSERT into downloads (guid,dlmethod,dlid,percentage,status,added) values (?,?,?,?,?,?)", guid, Method, Nzo_id, 0, DLStatusQueued, time.Now())
+	if err != nil {
+		log.Printf("AddDownload:%v", err)
+	}
+}
+
+// update progress, and if it has moved on the time it last moved
+func (s *SQLStore) UpdateDownload(guid string, st DownloadState, progressed bool) {
+	var err error
+	if progressed {
+		_, err = s.db.Exec("update downloads set percentage=?, status=?, mbleft=?, timeleft=?, lastprogress=? where guid=?", st.Percentage, st.Status, st.MBLeft, st.TimeLeft, time.Now(), guid)
+	} else {
+		_, err = s.db.Exec("update downloads set percentage=?, status=?, mbleft=?, timeleft=? where guid=?", st.Percentage, st.Status, st.MBLeft, st.TimeLeft, guid)
+	}
 	if err != nil {
-		log.Printf("MarkNZBDownload:%v", err)
+		log.Printf("UpdateDownload:%v", err)
 	}
 }
 
-func RemoveDownloadFromDB(guid string) {
-	_, err := db.Exec("delete from downloads where guid=?", guid)
+func (s *SQLStore) RemoveDownload(guid string) {
+	_, err := s.db.Exec("delete from downloads where guid=?", guid)
 	if err != nil {
-		log.Printf("RemoveDownloadFromDB:%v", err)
+		log.Printf("RemoveDownload:%v", err)
 	}
 }
@@ -0,0 +1,385 @@
+//downloadstuff.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// values for downloads.status
+const (
+	DLStatusQueued = iota
+	DLStatusDownloading
+	DLStatusPaused
+	DLStatusCompleted
+	DLStatusFailed
+	DLStatusStalled
+)
+
+// how long a download has to be missing from a client that
+// TracksAll before we decide it's been deleted
+const DLVanishGrace = 10 * time.Minute
+
+func DownloadStatusName(status int) string {
+	switch status {
+	case DLStatusQueued:
+		return "Queued"
+	case DLStatusDownloading:
+		return "Downloading"
+	case DLStatusPaused:
+		return "Paused"
+	case DLStatusCompleted:
+		return "Completed"
+	case DLStatusFailed:
+		return "Failed"
+	case DLStatusStalled:
+		return "Stalled"
+	}
+	return "Unknown"
+}
+
+// what a download client says about one of our downloads
+type DownloadState struct {
+	Status     int
+	Percentage int
+	Message    string
+	MBLeft     float64
+	TimeLeft   string
+}
+
+// A DownloadClient takes a link and hands back an id we
+// can use to follow it through to completed or failed.
+// TracksAll is true if States lists every download the client
+// has, so anything missing from it has been deleted.
+type DownloadClient interface {
+	Name() string     //stored in downloads.dlmethod
+	Protocol() string //usenet or torrent
+	SendURL(guid string, link string, nicename string) string
+	States() (map[string]DownloadState, error)
+	TracksAll() bool
+	Remove(dlid string, status int) bool
+}
+
+// true if a download hasn't moved for longer than MYSTALLTIMEOUT,
+// or has been fetching its nzb for longer than MYFETCHTIMEOUT
+func DownloadStalled(dl Downloads, st DownloadState) bool {
+	switch st.Message {
+	case "Fetching", "Grabbing":
+		return MYFETCHTIMEOUT > 0 && time.Since(dl.Added) > time.Duration(MYFETCHTIMEOUT)*time.Minute
+	}
+	//leave post processing alone, however long it takes
+	if st.Status != DLStatusDownloading || st.Percentage != dl.Percentage || st.Percentage >= 100 {
+		return false
+	}
+	lastprogress := dl.LastProgress
+	if lastprogress.IsZero() {
+		lastprogress = dl.Added
+	}
+	return MYSTALLTIMEOUT > 0 && time.Since(lastprogress) > time.Duration(MYSTALLTIMEOUT)*time.Minute
+}
+
+// true if a client that can't see every download (a blackhole) hasn't
+// shown us this one for longer than MYSTALLTIMEOUT since it was grabbed
+func DownloadUnseen(dl Downloads) bool {
+	return MYSTALLTIMEOUT > 0 && time.Since(dl.Added) > time.Duration(MYSTALLTIMEOUT)*time.Minute
+}
+
+// seconds as h:mm:ss, blank if unknown or forever
+func FormatTimeLeft(seconds int64) string {
+	if seconds < 0 || seconds >= 8640000 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds/60)%60, seconds%60)
+}
+
+// Configured download clients, the first one for a protocol is
+// the one that gets used unless the movie's profile names another
+var DownloadClients []DownloadClient
+
+// read the [[DOWNLOADER]] tables from the config, falling back to
+// a single SABnzbd using MYSABURL for older config files. Each
+// downloader files its downloads under its own CATEGORY
+func ReadDownloadClients(config *toml.Tree) {
+	DownloadClients = nil
+
+	tables, _ := config.Get("DOWNLOADER").([]*toml.Tree)
+	for _, t := range tables {
+		name := t.GetDefault("NAME", "").(string)
+		dtype := strings.ToLower(t.GetDefault("TYPE", "sabnzbd").(string))
+		dlurl := t.GetDefault("URL", "").(string)
+		apikey := t.GetDefault("APIKEY", "").(string)
+		username := t.GetDefault("USERNAME", "").(string)
+		password := t.GetDefault("PASSWORD", "").(string)
+		category := t.GetDefault("CATEGORY", "").(string)
+		removecompleted := t.GetDefault("REMOVECOMPLETED", false).(bool)
+		watchdir := t.GetDefault("WATCHDIR", "").(string)
+		completeddir := t.GetDefault("COMPLETEDDIR", "").(string)
+
+		switch dtype {
+		case "sabnzbd":
+			DownloadClients = append(DownloadClients, NewSABnzbd(name, dlurl, apikey, category))
+		case "nzbget":
+			DownloadClients = append(DownloadClients, NewNZBGet(name, dlurl, username, password, category))
+		case "qbittorrent":
+			DownloadClients = append(DownloadClients, NewQBittorrent(name, dlurl, username, password, category, removecompleted))
+		case "transmission":
+			DownloadClients = append(DownloadClients, NewTransmission(name, dlurl, username, password, category, removecompleted))
+		case "blackhole":
+			if watchdir == "" || completeddir == "" {
+				log.Printf("ReadDownloadClients:%s:Blackhole needs WATCHDIR and COMPLETEDDIR", name)
+				continue
+			}
+			DownloadClients = append(DownloadClients, NewBlackhole(name, watchdir, completeddir))
+		default:
+			log.Printf("ReadDownloadClients:UnknownType:%s:%s", name, dtype)
+			continue
+		}
+		log.Printf("ReadDownloadClients:Added:%s:%s", dtype, name)
+	}
+
+	if len(DownloadClients) == 0 && MYSABURL != "" {
+		DownloadClients = append(DownloadClients, NewSABnzbd("", MYSABURL, MYSABAPI, MYSABCAT))
+		log.Println("ReadDownloadClients:Added:sabnzbd from MYSABURL")
+	}
+
+	if len(DownloadClients) == 0 {
+		log.Println("ReadDownloadClients:No download clients configured")
+	}
+
+	//profiles are read first
+	for _, qp := range QualityProfiles {
+		for _, name := range qp.Downloaders {
+			if DownloadClientNamed(name) == nil {
+				log.Printf("ReadDownloadClients:Profile %s DOWNLOADERS has %q, no [[DOWNLOADER]] has that NAME", qp.Name, name)
+			}
+		}
+	}
+}
+
+// the download client to use for a protocol, the first of the quality
+// profile's DOWNLOADERS for it if it has any, otherwise the first one
+// configured. nil if there isn't one.
+func DownloadClientFor(protocol string, profile string) DownloadClient {
+	for _, name := range ProfileByName(profile).Downloaders {
+		if dc := DownloadClientNamed(name); dc != nil && dc.Protocol() == protocol {
+			return dc
+		}
+	}
+	for _, dc := range DownloadClients {
+		if dc.Protocol() == protocol {
+			return dc
+		}
+	}
+	return nil
+}
+
+// the download client with that name, nil if it isn't configured any more
+func DownloadClientNamed(name string) DownloadClient {
+	for _, dc := range DownloadClients {
+		if dc.Name() == name {
+			return dc
+		}
+	}
+	return nil
+}
+
+// take a movie's unfinished downloads out of their clients, files and all,
+// when it's archived. Its rows in downloads go too, or ParseDownloads
+// would take them for failures and grab another copy.
+func CancelMovieDownloads(st Store, movieid int64) {
+	refreshed := make(map[string]bool)
+	for _, dl := range st.Downloads("") {
+		if dl.MovieID != movieid {
+			continue
+		}
+		dc := DownloadClientNamed(dl.DlMethod)
+		if dc == nil {
+			log.Printf("CancelMovieDownloads:%s:No download client %s", dl.Nicename, dl.DlMethod)
+			continue
+		}
+		//qbittorrent needs to have seen the torrent to find it
+		if !refreshed[dc.Name()] {
+			dc.States()
+			refreshed[dc.Name()] = true
+		}
+		//same as a stalled download, out of the queue
+		if dc.Remove(dl.DlId, DLStatusStalled) {
+			log.Printf("CancelMovieDownloads:%s:Cancelled %s on %s", dl.Nicename, dl.DlId, dc.Name())
+			st.LogEvent(movieid, EventCancelled, dl.Guid, dc.Name(), dl.Nicename)
+			st.RemoveDownload(dl.Guid)
+			st.RevertMovieGrab(movieid)
+		}
+	}
+}
+
+//Grab NZB and mark database as grabbed or not, true if it was sent.
+//by is who asked, for the history.
+func GrabAndMark(st Store, guid string, movid int64, by string) bool {
+	//Get URL and Nicename from DB
+	nz, err := st.Release(guid)
+	if err != nil || nz.MovieId != movid {
+		log.Printf("GrabAndMark:No nzb %s for %d:%v", guid, movid, err)
+		return false
+	}
+	//archived movies are off the list, nothing more for them
+	if mv, err := st.Movie(movid); err != nil || !mv.Archived.IsZero() {
+		log.Printf("GrabAndMark:Movie %d is archived or gone, not grabbing %s:%v", movid, guid, err)
+		return false
+	}
+	dc := DownloadClientFor(nz.Protocol, nz.Profile)
+	if dc == nil {
+		log.Printf("GrabAndMark:No %s download client for %s", nz.Protocol, guid)
+		return false
+	}
+	//Send URL to the client, returns trackable ID
+	if nz.Link != "" {
+		dlid := dc.SendURL(guid, nz.Link, nz.Title)
+		if dlid != "" {
+			//Mark as grabbed for NZB and Movie
+			st.SetReleaseFlags(guid, 1, 0)
+			st.SetMovieGrab(movid, 1)
+			//Add to downloads
+			st.AddDownload(dlid, guid, dc.Name())
+			st.LogEvent(movid, EventGrabbed, guid, by, fmt.Sprintf("%s to %s", nz.Title, dc.Name()))
+			return true
+		}
+		//Issue with download, mark as ignored
+		st.SetReleaseFlags(guid, 0, 1)
+		st.LogEvent(movid, EventSendFailed, guid, by, fmt.Sprintf("%s to %s", nz.Title, dc.Name()))
+	}
+	return false
+}
+
+// best nzb for each ungrabbed movie, only looking at protocols
+// we have a download client for. The movie's quality profile decides,
+// best ranked quality first and then highest score within that rank.
+// With MYUPGRADES on, downloaded movies that haven't reached their
+// profile's cutoff get a strictly better ranked nzb if there is one.
+func GrabbableList(st Store, protocols []string) []Grabbable {
+	var gbs []Grabbable
+	best := make(map[int64]int) //movieid to index in gbs
+	for _, gb := range st.GrabCandidates(protocols, MYUPGRADES) {
+		qp := ProfileByName(gb.Profile)
+		gb.Rank = qp.Rank(gb.Quality)
+		if gb.Rank < 0 {
+			//not a quality this movie wants
+			continue
+		}
+		if gb.MovieGrabbed == 1 {
+			//upgrade, only if we haven't got what we want and this is better
+			dlrank := qp.Rank(gb.DlQuality)
+			if qp.CutoffMet(gb.DlQuality) || (dlrank >= 0 && gb.Rank >= dlrank) {
+				continue
+			}
+			gb.Upgrade = true
+		}
+		i, ok := best[gb.MovieId]
+		switch {
+		case !ok:
+			best[gb.MovieId] = len(gbs)
+			gbs = append(gbs, gb)
+		case gb.Rank < gbs[i].Rank:
+			//candidates come highest score first, so only a better rank wins
+			gbs[i] = gb
+		}
+	}
+	return gbs
+}
+
+// ask each download client how our downloads are getting on,
+// failed ones free the movie up for the next best nzb
+func ParseDownloads(st Store) {
+	for _, dc := range DownloadClients {
+		states, err := dc.States()
+		if err != nil {
+			log.Printf("ParseDownloads:%s:%v", dc.Name(), err)
+			continue
+		}
+
+		//get downloads from the db
+		dls := st.Downloads(dc.Name())
+		for _, dl := range dls {
+			state, ok := states[dl.DlId]
+			if !ok {
+				if !dc.TracksAll() {
+					//out of sight in the downloader, but not forever
+					if DownloadUnseen(dl) {
+						dc.Remove(dl.DlId, DLStatusStalled)
+						FailDownload(st, dl, "Not seen in "+dc.Name()+" since it was grabbed")
+						log.Printf("%s:Unseen:Removed %s from downloads table with id %s", dc.Name(), dl.Nicename, dl.DlId)
+					}
+					continue
+				}
+				if time.Since(dl.Added) < DLVanishGrace {
+					continue
+				}
+				//gone from the client without finishing, treat as failed
+				FailDownload(st, dl, "Vanished from "+dc.Name())
+				log.Printf("%s:Vanished:Removed %s from downloads table with id %s", dc.Name(), dl.Nicename, dl.DlId)
+				continue
+			}
+			switch state.Status {
+			case DLStatusFailed:
+				FailDownload(st, dl, state.Message)
+				dc.Remove(dl.DlId, state.Status)
+				log.Printf("%s:Failed:Removed %s from downloads table with id %s %s", dc.Name(), dl.Nicename, dl.DlId, state.Message)
+			case DLStatusCompleted:
+				//download completed ok - delete item from list
+				st.SetMovieGrab(dl.MovieID, 1)
+				if up, ok := st.SetMovieDownloaded(dl.MovieID, dl.Guid); ok {
+					log.Printf("ParseDownloads:Upgraded %d from %s to %s", dl.MovieID, up.OldQuality, up.NewQuality)
+					st.LogEvent(dl.MovieID, EventUpgraded, dl.Guid, dc.Name(), fmt.Sprintf("%s %s to %s %s", up.OldQuality, up.OldTitle, up.NewQuality, up.NewTitle))
+				}
+				st.SetReleaseFlags(dl.Guid, 1, 0)
+				st.RemoveDownload(dl.Guid)
+				st.LogEvent(dl.MovieID, EventCompleted, dl.Guid, dc.Name(), dl.Nicename)
+				RecordDownloadResult(st, dl.Guid, true)
+				dc.Remove(dl.DlId, state.Status)
+				log.Printf("%s:Completed:Removed %s from downloads table with id %s", dc.Name(), dl.Nicename, dl.DlId)
+			default:
+				if DownloadStalled(dl, state) {
+					//take it out of the client so the next best nzb can be grabbed
+					dc.Remove(dl.DlId, DLStatusStalled)
+					FailDownload(st, dl, fmt.Sprintf("Stalled at %d%% %s", state.Percentage, state.Message))
+					log.Printf("%s:Stalled:Removed %s from downloads table with id %s at %d%% %s", dc.Name(), dl.Nicename, dl.DlId, state.Percentage, state.Message)
+					continue
+				}
+				st.UpdateDownload(dl.Guid, state, state.Percentage != dl.Percentage)
+			}
+		}
+	}
+}
+
+// a download that didn't work out - free the movie up,
+// ignore the nzb and stop tracking it, then grab another
+// copy of the same release if there is one
+func FailDownload(st Store, dl Downloads, why string) {
+	st.LogEvent(dl.MovieID, EventFailed, dl.Guid, dl.DlMethod, strings.TrimSpace(dl.Nicename+" "+why))
+	//download failed - mark movie not grabbed, unless it was an upgrade
+	st.RevertMovieGrab(dl.MovieID)
+	//mark nzb grabbed ignored, leave item in list
+	st.SetReleaseFlags(dl.Guid, 1, 1)
+	//delete download record from db
+	st.RemoveDownload(dl.Guid)
+	//count it against the group and indexer
+	RecordDownloadResult(st, dl.Guid, false)
+	//try the same release from another indexer before a different release
+	for _, guid := range st.SiblingSources(dl.Guid) {
+		if GrabAndMark(st, guid, dl.MovieID, "scheduler") {
+			log.Printf("FailDownload:%s failed, grabbed the same release as %s", dl.Guid, guid)
+			break
+		}
+	}
+}
+
+// the protocols we have a download client for
+func DownloadProtocols() (protocols []string) {
+	for _, dc := range DownloadClients {
+		protocols = append(protocols, dc.Protocol())
+	}
+	return protocols
+}
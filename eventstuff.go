@@ -0,0 +1,61 @@
+//eventstuff.go
+package main
+
+import "time"
+
+// Something that happened to a movie, or to the setup in general
+// (MovieId 0), kept in the events table for the movie timeline and
+// the History page. By is what did it - the watchlist, an indexer,
+// a download client, the scheduler or "web" and who from.
+type Event struct {
+	Id         int64
+	When       time.Time
+	MovieId    int64
+	MovieTitle string //blank once the movie's been purged
+	Kind       string
+	Guid       string
+	By         string
+	Detail     string
+}
+
+const (
+	EventAdded         = "added"
+	EventArchived      = "archived"
+	EventArchiveRefuse = "archive refused"
+	EventRestored      = "restored"
+	EventPurged        = "purged"
+	EventFound         = "found"
+	EventSearched      = "searched"
+	EventGrabbed       = "grabbed"
+	EventSendFailed    = "send failed"
+	EventCompleted     = "completed"
+	EventFailed        = "failed"
+	EventCancelled     = "cancelled"
+	EventUpgraded      = "upgraded"
+	EventUngrabbed     = "ungrabbed"
+	EventIgnored       = "ignored"
+	EventUnignored     = "unignored"
+	EventProfile       = "profile"
+	EventRule          = "rule"
+	EventScript        = "script"
+	EventGroup         = "group"
+	EventBackup        = "backup"
+)
+
+// for the filter on the History page
+var EventKinds = []string{EventAdded, EventArchived, EventArchiveRefuse, EventRestored, EventPurged,
+	EventFound, EventSearched, EventGrabbed, EventSendFailed, EventCompleted, EventFailed, EventCancelled,
+	EventUpgraded, EventUngrabbed, EventIgnored, EventUnignored, EventProfile, EventRule, EventScript, EventGroup, EventBackup}
+
+// what to show on the History page, zero values match everything
+type EventFilter struct {
+	MovieId int64
+	Kind    string
+	By      string //prefix, so "web" is every web action
+	Search  string //in the movie title or detail
+	Days    int
+	Limit   int
+}
+
+// most events a page shows
+const EventLimit = 500
@@ -0,0 +1,131 @@
+//groupstuff.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How downloads from a release group or an indexer have gone.
+// Groups can also be blocked or preferred from the Groups page.
+type SourceStats struct {
+	Name       string
+	Failed     int
+	Completed  int
+	Policy     string //GroupBlock, GroupPrefer or blank
+	LastFailed time.Time
+}
+
+const (
+	GroupBlock  = "block"
+	GroupPrefer = "prefer"
+)
+
+// where the stats are kept, for a StatsStore
+const (
+	StatsGroups   = "groupstats"
+	StatsIndexers = "indexerstats"
+)
+
+var (
+	MYFAILLIMIT   int     //Failures before a group or indexer is penalised
+	MYFAILPENALTY float64 //Score penalty per failure over successes, once over MYFAILLIMIT
+	MYGROUPBONUS  float64 //Score bonus for a preferred group
+
+	groupStats   map[string]SourceStats //cached from groupstats, by lowercase name
+	indexerStats map[string]SourceStats //cached from indexerstats, by lowercase name
+	statsLock    sync.RWMutex
+)
+
+// penalty for a group or indexer that keeps failing, 0 until it's
+// failed MYFAILLIMIT times and only while failures outnumber successes
+func (ss SourceStats) Penalty() float64 {
+	if MYFAILLIMIT <= 0 || ss.Failed < MYFAILLIMIT || ss.Failed <= ss.Completed {
+		return 0
+	}
+	return -MYFAILPENALTY * float64(ss.Failed-ss.Completed)
+}
+
+// swap in new stats, loaded by LoadSourceStats
+func SetSourceStats(groups []SourceStats, indexers []SourceStats) {
+	gm := make(map[string]SourceStats)
+	for _, ss := range groups {
+		gm[strings.ToLower(ss.Name)] = ss
+	}
+	im := make(map[string]SourceStats)
+	for _, ss := range indexers {
+		im[strings.ToLower(ss.Name)] = ss
+	}
+	statsLock.Lock()
+	groupStats, indexerStats = gm, im
+	statsLock.Unlock()
+}
+
+// stats for a release group, zero if we've never downloaded one
+func GroupStatsFor(group string) SourceStats {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	return groupStats[strings.ToLower(group)]
+}
+
+// stats for an indexer, zero if we've never downloaded from it
+func IndexerStatsFor(indexer string) SourceStats {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	return indexerStats[strings.ToLower(indexer)]
+}
+
+// score adjustment from the group and indexer history, with what it's
+// made of for the breakdown, and a reason if the group is blocked
+func HistoryScore(nz NZB) (adjust float64, notes []string, blocked string) {
+	if nz.Group != "" {
+		gs := GroupStatsFor(nz.Group)
+		switch gs.Policy {
+		case GroupBlock:
+			return 0, nil, fmt.Sprintf("Group %s is blocked", nz.Group)
+		case GroupPrefer:
+			adjust += MYGROUPBONUS
+			notes = append(notes, fmt.Sprintf("Group %s preferred %+0.0f", nz.Group, MYGROUPBONUS))
+		}
+		if p := gs.Penalty(); p != 0 {
+			adjust += p
+			notes = append(notes, fmt.Sprintf("Group %s failed %d/%d %+0.0f", nz.Group, gs.Failed, gs.Failed+gs.Completed, p))
+		}
+	}
+	if nz.Indexer != "" {
+		is := IndexerStatsFor(nz.Indexer)
+		if p := is.Penalty(); p != 0 {
+			adjust += p
+			notes = append(notes, fmt.Sprintf("Indexer %s failed %d/%d %+0.0f", nz.Indexer, is.Failed, is.Failed+is.Completed, p))
+		}
+	}
+	return adjust, notes, ""
+}
+
+// refresh the group and indexer history GetScore uses from the db
+func LoadSourceStats(st Store) {
+	SetSourceStats(st.SourceStats(StatsGroups), st.SourceStats(StatsIndexers))
+}
+
+// count a finished download against its group and indexer, then
+// rescore their releases so a group that keeps failing drops down the list
+func RecordDownloadResult(st Store, guid string, completed bool) {
+	nz, err := st.Release(guid)
+	if err != nil || (nz.Group == "" && nz.Indexer == "") {
+		return
+	}
+	if nz.Group != "" {
+		st.RecordSourceResult(StatsGroups, nz.Group, completed)
+	}
+	if nz.Indexer != "" {
+		st.RecordSourceResult(StatsIndexers, nz.Indexer, completed)
+	}
+	LoadSourceStats(st)
+	err = RescoreReleases(st, st.ReleasesFrom(nz.Group, nz.Indexer))
+	if err != nil {
+		log.Println("RecordDownloadResult:SetReleaseScores", err)
+	}
+}
@@ -0,0 +1,268 @@
+//indexerstuff.go
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// An Indexer is anything that can give us release lists
+// in the NZBGRSS format
+type Indexer interface {
+	Name() string
+	LatestMovies() (*NZBGRSS, error)
+	MovieByIMDB(IMDB int64) (*NZBGRSS, error)
+	MovieByTitle(Title string) (*NZBGRSS, error)
+}
+
+// Configured indexers, searched in order
+var Indexers []Indexer
+
+// read the [[INDEXER]] tables from the config, falling back to
+// a single NZBGeek indexer using MYAPIKEY for older config files
+func ReadIndexers(config *toml.Tree) {
+	Indexers = nil
+
+	tables, _ := config.Get("INDEXER").([]*toml.Tree)
+	for _, t := range tables {
+		name := t.GetDefault("NAME", "").(string)
+		itype := strings.ToLower(t.GetDefault("TYPE", "nzbgeek").(string))
+		apikey := t.GetDefault("APIKEY", "").(string)
+		apiurl := t.GetDefault("URL", "").(string)
+		categories := t.GetDefault("CATEGORIES", "").(string)
+
+		switch itype {
+		case "nzbgeek":
+			Indexers = append(Indexers, NewNZBGeek(name, apikey))
+		case "newznab":
+			if apiurl == "" {
+				log.Printf("ReadIndexers:%s:Newznab needs a URL", name)
+				continue
+			}
+			Indexers = append(Indexers, NewNewznab(name, apiurl, apikey, categories))
+		case "torznab":
+			if apiurl == "" {
+				log.Printf("ReadIndexers:%s:Torznab needs a URL", name)
+				continue
+			}
+			Indexers = append(Indexers, NewTorznab(name, apiurl, apikey, categories))
+		default:
+			log.Printf("ReadIndexers:UnknownType:%s:%s", name, itype)
+			continue
+		}
+		log.Printf("ReadIndexers:Added:%s:%s", itype, name)
+	}
+
+	if len(Indexers) == 0 && MYAPIKEY != "" {
+		Indexers = append(Indexers, NewNZBGeek("", MYAPIKEY))
+		log.Println("ReadIndexers:Added:nzbgeek from MYAPIKEY")
+	}
+
+	if len(Indexers) == 0 {
+		log.Println("ReadIndexers:No indexers configured")
+	}
+}
+
+// search every indexer for a movie by imdb id, and if an indexer
+// has nothing for it try by title instead. Returns count added.
+func SearchIndexersForMovie(st Store, id int64, title string) (count int) {
+	for _, ix := range Indexers {
+		nz, err := ix.MovieByIMDB(id)
+		if err != nil {
+			log.Printf("SearchIndexersForMovie:%s:MovieByIMDB:%d:%v", ix.Name(), id, err)
+			continue
+		}
+		if len(nz.Channels.NZBGItems) == 0 && title != "" {
+			nz, err = ix.MovieByTitle(title)
+			if err != nil {
+				log.Printf("SearchIndexersForMovie:%s:MovieByTitle:%s:%v", ix.Name(), title, err)
+				continue
+			}
+		}
+		count += NZBGRSStoDB(st, nz)
+	}
+	return count
+}
+
+// loop the NZBGRSS struct and
+// if the movie exists we add the
+// file to the releases table using
+// the returned id
+func NZBGRSStoDB(st Store, nz *NZBGRSS) (count int) {
+	var id int64
+	var coverurl string
+	var usenetdate string
+	var nzb NZB
+
+	protocol := nz.Protocol
+	if protocol == "" {
+		protocol = "usenet"
+	}
+
+	for _, mv := range nz.Channels.NZBGItems {
+
+		//reset inner loop vars
+		id = 0
+		usenetdate = ""
+		coverurl = ""
+		nzb = NZB{Title: mv.Title, Link: mv.Link, Protocol: protocol, Indexer: nz.Indexer, Release: ParseRelease(mv.Title)}
+
+		for _, nza := range mv.NZAttribs {
+
+			//log.Printf("%s = %s\n", nza.Name, nza.Value)
+
+			switch strings.ToLower(nza.Name) {
+
+			case "grabs":
+				nzb.Grabs, _ = strconv.Atoi(nza.Value)
+
+			case "size":
+				nzb.Size, _ = strconv.ParseFloat(nza.Value, 64)
+
+			case "coverurl":
+				coverurl = nza.Value
+
+			case "guid":
+				nzb.Id = nza.Value
+
+			case "usenetdate":
+				usenetdate = nza.Value
+
+			case "imdb", "imdbid":
+				id, _ = strconv.ParseInt(strings.TrimPrefix(strings.ToLower(nza.Value), "tt"), 10, 64)
+
+			//torznab
+			case "seeders":
+				nzb.Seeders, _ = strconv.Atoi(nza.Value)
+
+			case "peers":
+				nzb.Peers, _ = strconv.Atoi(nza.Value)
+
+			case "infohash":
+				nzb.InfoHash = strings.ToLower(nza.Value)
+
+			case "magneturl":
+				nzb.Magnet = nza.Value
+			}
+		}
+
+		//torznab feeds don't always have the attrs, use the item fields instead
+		if nzb.Size == 0 {
+			nzb.Size, _ = strconv.ParseFloat(mv.Size, 64)
+		}
+		if nzb.Size == 0 {
+			nzb.Size, _ = strconv.ParseFloat(mv.Enclosure.Length, 64)
+		}
+		nzb.Size = nzb.Size / (1024 * 1024 * 1024)
+		if usenetdate == "" {
+			usenetdate = mv.PubDate
+		}
+		if nzb.Id == "" {
+			nzb.Id = mv.Guid
+		}
+		//the infohash is the torrent anyway
+		if nzb.Protocol == "torrent" && nzb.InfoHash != "" {
+			nzb.Id = nzb.InfoHash
+		} else if nzb.Protocol == "torrent" || strings.ContainsAny(nzb.Id, "/:") {
+			nzb.Id = HashGuid(nzb.Id)
+		}
+		if nzb.Link == "" {
+			nzb.Link = mv.Enclosure.URL
+		}
+		if nzb.Link == "" {
+			nzb.Link = nzb.Magnet
+		}
+
+		//if we can find imdbid in our database then we add the file to the list, and calculate
+		//some kind of score based on text in like and hate lists
+		if id == 0 {
+			continue
+		}
+		mov, err := st.Movie(id)
+		if err != nil || !mov.Archived.IsZero() {
+			continue
+		}
+
+		nzb.UsenetDate, _ = time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", usenetdate)
+		nzb.MovieId, nzb.MovieName = id, mov.Title
+		nzb.Runtime, nzb.Profile = mov.Runtime, mov.Profile
+		nzb.Quality = QualityName(nzb.Release)
+		nzb.ScoreDetail = GetScore(nzb)
+		nzb.Score = nzb.ScoreDetail.Total
+		nzb.NormTitle = NormaliseTitle(nzb.Title)
+		nzb.ReleaseId = FindReleaseID(st, id, nzb.NormTitle, nzb.Size, nzb.Id)
+		added, err := st.AddRelease(nzb)
+		switch {
+		case err != nil:
+			log.Println("NZBGRSStoDB:AddRelease:", err)
+		case added:
+			st.SetMovieCover(id, coverurl)
+			log.Printf("Found %s id %s on %s for %d %s with score %.0f", nzb.Protocol, nzb.Id, nzb.Indexer, id, nzb.Title, nzb.Score)
+			st.LogEvent(id, EventFound, nzb.Id, nzb.Indexer, fmt.Sprintf("%s, score %.0f", nzb.Title, nzb.Score))
+			count += 1
+		case nzb.Protocol == "torrent":
+			//already have it, but the swarm changes so keep it current,
+			//and score it again as it may have grown past MYMINSEEDERS
+			st.SetReleaseSeeders(nzb.Id, nzb.Seeders, nzb.Peers)
+			err = st.SetReleaseScores([]NZB{nzb})
+			if err != nil {
+				log.Println("NZBGRSStoDB:SetReleaseScores:", err)
+			}
+		}
+	}
+	return count
+}
+
+// torznab guids, and item guids when there's no guid attr, are mostly
+// urls, slashes and all, which won't go in our links
+func HashGuid(guid string) string {
+	if guid == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(guid)))
+}
+
+// sizes of the same release from different indexers aren't always
+// quite the same, this is how far apart they can be
+const DupeSizeTolerance = 0.02
+
+// releaseid of a copy of the same release we already have for the
+// movie, same normalised title and near enough the same size, or
+// guid if this is the first we've seen of it
+func FindReleaseID(st Store, movieid int64, normtitle string, size float64, guid string) string {
+	for _, other := range st.ReleasesNamed(movieid, normtitle) {
+		if other.Id == guid {
+			continue
+		}
+		if math.Abs(size-other.Size) <= DupeSizeTolerance*math.Max(size, other.Size) {
+			return other.ReleaseId
+		}
+	}
+	return guid
+}
+
+// match up the nzbs we had before duplicates were tracked
+func GroupReleases(st Store) {
+	nzbs := st.UngroupedReleases()
+
+	//one at a time, so each can find the ones before it
+	for _, nz := range nzbs {
+		normtitle := NormaliseTitle(nz.Title)
+		releaseid := FindReleaseID(st, nz.MovieId, normtitle, nz.Size, nz.Id)
+		err := st.SetReleaseId(nz.Id, normtitle, releaseid)
+		if err != nil {
+			log.Println("GroupReleases:SetReleaseId", err)
+			return
+		}
+	}
+	if len(nzbs) > 0 {
+		log.Printf("GroupReleases:Grouped %d nzbs", len(nzbs))
+	}
+}
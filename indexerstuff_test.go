@@ -0,0 +1,78 @@
+//indexerstuff_test.go
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// a feed item for movie 1 of 10Gb
+func testItem(title string, guid string, attrs ...NZAttrib) NZBGItem {
+	it := NZBGItem{Title: title, Guid: guid, Link: "http://indexer/get/" + title, PubDate: "Mon, 02 Jan 2006 15:04:05 -0700"}
+	it.NZAttribs = append([]NZAttrib{{"imdb", "0000001"}, {"size", "10737418240"}}, attrs...)
+	return it
+}
+
+func testFeed(protocol string, items ...NZBGItem) *NZBGRSS {
+	nz := &NZBGRSS{Indexer: "one", Protocol: protocol}
+	nz.Channels.NZBGItems = items
+	return nz
+}
+
+func TestNZBGRSStoDBIds(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		item     NZBGItem
+		id       string
+	}{
+		{"guid attr", "usenet", testItem("Heat.1995.1080p.BluRay.x264-A", "https://indexer/details/1", NZAttrib{"guid", "abc123"}), "abc123"},
+		{"url guid", "usenet", testItem("Heat.1995.1080p.BluRay.x264-B", "https://indexer/details/2"), HashGuid("https://indexer/details/2")},
+		{"urn guid", "usenet", testItem("Heat.1995.1080p.BluRay.x264-C", "urn:uuid:3"), HashGuid("urn:uuid:3")},
+		{"infohash", "torrent", testItem("Heat.1995.1080p.BluRay.x264-D", "https://tracker/4", NZAttrib{"infohash", "ABCDEF0123"}), "abcdef0123"},
+		{"torrent guid", "torrent", testItem("Heat.1995.1080p.BluRay.x264-E", "5"), HashGuid("5")},
+	}
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+		for _, tt := range tests {
+			if n := NZBGRSStoDB(st, testFeed(tt.protocol, tt.item)); n != 1 {
+				t.Errorf("%s: added %d", tt.name, n)
+			}
+			if nz, err := st.Release(tt.id); err != nil || nz.Title != tt.item.Title {
+				t.Errorf("%s: Release(%q) = %q, %v", tt.name, tt.id, nz.Title, err)
+			}
+			if strings.ContainsAny(tt.id, "/:") {
+				t.Errorf("%s: id %q won't go in a link", tt.name, tt.id)
+			}
+		}
+	})
+}
+
+// a torrent rejected for too few seeders comes back when the swarm grows
+func TestNZBGRSStoDBSeeders(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+		hash := NZAttrib{"infohash", "abcdef0123"}
+
+		NZBGRSStoDB(st, testFeed("torrent", testItem("Heat.1995.1080p.BluRay.x264-GRP", "1", hash, NZAttrib{"seeders", "0"})))
+		nz, _ := st.Release("abcdef0123")
+		if nz.Score > 0 || nz.Ignored != 1 {
+			t.Fatalf("no seeders, score %v ignored %d", nz.Score, nz.Ignored)
+		}
+
+		NZBGRSStoDB(st, testFeed("torrent", testItem("Heat.1995.1080p.BluRay.x264-GRP", "1", hash, NZAttrib{"seeders", "12"})))
+		nz, _ = st.Release("abcdef0123")
+		if nz.Seeders != 12 || nz.Score <= 0 || nz.Ignored != 0 || nz.ScoreDetail.Reason != "" {
+			t.Errorf("%d seeders, score %v ignored %d %q", nz.Seeders, nz.Score, nz.Ignored, nz.ScoreDetail.Reason)
+		}
+
+		//ignored by hand stays ignored
+		st.SetReleaseFlags("abcdef0123", 0, 1)
+		NZBGRSStoDB(st, testFeed("torrent", testItem("Heat.1995.1080p.BluRay.x264-GRP", "1", hash, NZAttrib{"seeders", "20"})))
+		if nz, _ = st.Release("abcdef0123"); nz.Ignored != 1 {
+			t.Errorf("un-ignored a release ignored by hand")
+		}
+	})
+}
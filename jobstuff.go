@@ -0,0 +1,52 @@
+//jobstuff.go
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// A job the scheduler runs every so often, which can also be started
+// from the api. Only one run of each at a time, or a grab from the api
+// could send the same release the scheduler's sending.
+type Job struct {
+	Name string
+	Run  func(st Store, by string) //by is who started it, for the history
+	lock sync.Mutex
+}
+
+var Jobs = map[string]*Job{
+	"watchlist": {Name: "watchlist", Run: func(st Store, by string) { RSS2WatchlistUpdate(st) }},
+	"latest":    {Name: "latest", Run: func(st Store, by string) { MostRecentMovieList(st) }},
+	"search":    {Name: "search", Run: func(st Store, by string) { UnGrabbedMovies(st) }},
+	"grab":      {Name: "grab", Run: func(st Store, by string) { DownloadGrabbableMovies(st) }},
+	"rescore":   {Name: "rescore", Run: func(st Store, by string) { UpdateNZBScores(st) }},
+	"backup": {Name: "backup", Run: func(st Store, by string) {
+		if _, err := TakeBackup(st, by); err != nil {
+			log.Println("Jobs:backup:", err)
+		}
+	}},
+}
+
+// run it now for the scheduler, unless it's already running
+func (j *Job) Scheduled(st Store) {
+	if !j.lock.TryLock() {
+		log.Printf("Jobs:%s:Still running, skipped", j.Name)
+		return
+	}
+	defer j.lock.Unlock()
+	j.Run(st, "scheduler")
+}
+
+// start it in the background, false if it's already running
+func (j *Job) Start(st Store, by string) bool {
+	if !j.lock.TryLock() {
+		return false
+	}
+	log.Printf("Jobs:%s:Started by %s", j.Name, by)
+	go func() {
+		defer j.lock.Unlock()
+		j.Run(st, by)
+	}()
+	return true
+}
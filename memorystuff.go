@@ -0,0 +1,647 @@
+//memorystuff.go
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MYDBDSN for a MemoryStore instead of a database
+const MemoryDSN = "memory"
+
+// A Store that keeps everything in maps and forgets it on exit, for
+// trying out rules and profiles, or exercising the scoring and grab
+// decisions without a database file. Lists come back in the same
+// order SQLStore gives them.
+type MemoryStore struct {
+	lock      sync.RWMutex
+	movies    map[int64]Movie
+	nzbs      map[string]NZB
+	downloads map[string]Downloads //by nzb guid
+	upgrades  []Upgrade
+	events    []Event
+	rules     []ScoreRule
+	stats     map[string]map[string]SourceStats //by table then lowercase name
+	lastid    int64                             //for events and rules
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		movies:    make(map[int64]Movie),
+		nzbs:      make(map[string]NZB),
+		downloads: make(map[string]Downloads),
+		stats:     map[string]map[string]SourceStats{StatsGroups: {}, StatsIndexers: {}},
+	}
+}
+
+func (m *MemoryStore) AddMovie(id int64, title string, runtime int) (added bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.movies[id]; ok {
+		return false, nil
+	}
+	m.movies[id] = Movie{Id: id, Title: title, Runtime: runtime}
+	return true, nil
+}
+
+func (m *MemoryStore) Movie(id int64) (Movie, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	mv, ok := m.movies[id]
+	if !ok {
+		return mv, ErrNotFound
+	}
+	return mv, nil
+}
+
+// nzb and ignored counts for a movie, lock held
+func (m *MemoryStore) counts(movieid int64) (nzbcount int, ignorecount int) {
+	for _, nz := range m.nzbs {
+		if nz.MovieId == movieid {
+			nzbcount++
+			ignorecount += nz.Ignored
+		}
+	}
+	return nzbcount, ignorecount
+}
+
+func (m *MemoryStore) Movies() (mvs []Movie) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, mv := range m.movies {
+		if !mv.Archived.IsZero() {
+			continue
+		}
+		mv.NzbCount, mv.IgnoreCount = m.counts(mv.Id)
+		mv.Orderfield = 1
+		if (1-mv.Grabbed)*(mv.NzbCount-mv.IgnoreCount) > 0 {
+			mv.Orderfield = 0
+		}
+		mvs = append(mvs, mv)
+	}
+	sort.Slice(mvs, func(i, j int) bool {
+		a, b := mvs[i], mvs[j]
+		if a.Orderfield != b.Orderfield {
+			return a.Orderfield < b.Orderfield
+		}
+		if a.Grabbed != b.Grabbed {
+			return a.Grabbed < b.Grabbed
+		}
+		return a.Title < b.Title
+	})
+	return mvs
+}
+
+func (m *MemoryStore) WantedMovies(upgrades bool) (mvs []Movie) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, mv := range m.movies {
+		if mv.Archived.IsZero() && (mv.Grabbed == 0 || (mv.DlGuid != "" && upgrades)) {
+			mvs = append(mvs, mv)
+		}
+	}
+	sort.Slice(mvs, func(i, j int) bool { return mvs[i].Id < mvs[j].Id })
+	return mvs
+}
+
+func (m *MemoryStore) ArchivedMovies() (mvs []Movie) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, mv := range m.movies {
+		if !mv.Archived.IsZero() {
+			mv.NzbCount, _ = m.counts(mv.Id)
+			mvs = append(mvs, mv)
+		}
+	}
+	sort.Slice(mvs, func(i, j int) bool { return mvs[i].Archived.After(mvs[j].Archived) })
+	return mvs
+}
+
+func (m *MemoryStore) ArchiveMovie(id int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mv, ok := m.movies[id]
+	if !ok || !mv.Archived.IsZero() {
+		return false
+	}
+	mv.Archived = time.Now()
+	m.movies[id] = mv
+	return true
+}
+
+func (m *MemoryStore) RestoreMovie(id int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mv, ok := m.movies[id]
+	if !ok || mv.Archived.IsZero() {
+		return false
+	}
+	mv.Archived = time.Time{}
+	m.movies[id] = mv
+	return true
+}
+
+// the events stay, same as the database
+func (m *MemoryStore) DeleteMovie(id int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.movies, id)
+	for guid, nz := range m.nzbs {
+		if nz.MovieId == id {
+			delete(m.nzbs, guid)
+			delete(m.downloads, guid)
+		}
+	}
+	var ups []Upgrade
+	for _, up := range m.upgrades {
+		if up.MovieId != id {
+			ups = append(ups, up)
+		}
+	}
+	m.upgrades = ups
+	return true
+}
+
+// apply f to movie id if we have it, true if we did
+func (m *MemoryStore) updateMovie(id int64, f func(mv *Movie)) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mv, ok := m.movies[id]
+	if !ok {
+		return false
+	}
+	f(&mv)
+	m.movies[id] = mv
+	return true
+}
+
+func (m *MemoryStore) SetMovieRuntime(id int64, runtime int) bool {
+	changed := false
+	m.updateMovie(id, func(mv *Movie) {
+		changed = mv.Runtime != runtime
+		mv.Runtime = runtime
+	})
+	return changed
+}
+
+func (m *MemoryStore) SetMovieManual(id int64, manual bool) {
+	m.updateMovie(id, func(mv *Movie) { mv.Manual = manual })
+}
+
+func (m *MemoryStore) SetMovieProfile(id int64, profile string) {
+	m.updateMovie(id, func(mv *Movie) { mv.Profile = profile })
+}
+
+func (m *MemoryStore) SetMovieCover(id int64, coverurl string) {
+	m.updateMovie(id, func(mv *Movie) { mv.CoverUrl = coverurl })
+}
+
+func (m *MemoryStore) SetMovieGrab(id int64, grabflag int) {
+	m.updateMovie(id, func(mv *Movie) { mv.Grabbed = grabflag })
+}
+
+func (m *MemoryStore) RevertMovieGrab(id int64) {
+	m.updateMovie(id, func(mv *Movie) {
+		mv.Grabbed = 0
+		if mv.DlGuid != "" {
+			mv.Grabbed = 1
+		}
+	})
+}
+
+func (m *MemoryStore) SetMovieDownloaded(id int64, guid string) (up Upgrade, upgraded bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mv, ok := m.movies[id]
+	if !ok {
+		log.Printf("SetMovieDownloaded:Movie:%d:%v", id, ErrNotFound)
+		return up, false
+	}
+	nz, ok := m.nzbs[guid]
+	if !ok {
+		log.Printf("SetMovieDownloaded:NZB:%s:%v", guid, ErrNotFound)
+		return up, false
+	}
+	old := mv
+	mv.DlGuid, mv.DlTitle, mv.DlQuality, mv.DlScore = guid, nz.Title, nz.Quality, nz.Score
+	m.movies[id] = mv
+	if old.DlGuid == "" || old.DlGuid == guid {
+		return up, false
+	}
+	up = Upgrade{id, old.DlTitle, old.DlQuality, old.DlScore, nz.Title, nz.Quality, nz.Score, time.Now()}
+	m.upgrades = append(m.upgrades, up)
+	return up, true
+}
+
+func (m *MemoryStore) ClearMovieDownloaded(id int64) {
+	m.updateMovie(id, func(mv *Movie) {
+		mv.DlGuid, mv.DlTitle, mv.DlQuality, mv.DlScore = "", "", "", 0
+	})
+}
+
+func (m *MemoryStore) Upgrades(id int64) (ups []Upgrade) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for i := len(m.upgrades) - 1; i >= 0; i-- {
+		if m.upgrades[i].MovieId == id {
+			ups = append(ups, m.upgrades[i])
+		}
+	}
+	return ups
+}
+
+// the nzb as it would come out of the database,
+// false if it or its movie has gone. Lock held.
+func (m *MemoryStore) release(guid string) (NZB, bool) {
+	nz, ok := m.nzbs[guid]
+	if !ok {
+		return nz, false
+	}
+	mv, ok := m.movies[nz.MovieId]
+	if !ok {
+		return nz, false
+	}
+	nz.MovieName, nz.Runtime, nz.Profile = mv.Title, mv.Runtime, mv.Profile
+	nz.Sources = nil
+	return nz, true
+}
+
+// nzbs matching keep in the order less puts them, lock held
+func (m *MemoryStore) releases(keep func(nz NZB) bool, less func(a, b NZB) bool) (nzbs []NZB) {
+	for guid := range m.nzbs {
+		nz, ok := m.release(guid)
+		if ok && keep(nz) {
+			nzbs = append(nzbs, nz)
+		}
+	}
+	sort.Slice(nzbs, func(i, j int) bool {
+		if less(nzbs[i], nzbs[j]) {
+			return true
+		}
+		if less(nzbs[j], nzbs[i]) {
+			return false
+		}
+		return nzbs[i].Id < nzbs[j].Id
+	})
+	return nzbs
+}
+
+func oldestFirst(a, b NZB) bool { return a.UsenetDate.Before(b.UsenetDate) }
+
+func (m *MemoryStore) AddRelease(nz NZB) (added bool, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.nzbs[nz.Id]; ok {
+		return false, nil
+	}
+	if _, ok := m.movies[nz.MovieId]; !ok {
+		return false, ErrNotFound
+	}
+	nz.Grabbed, nz.Ignored, nz.AutoIgnored = 0, 1, true
+	if nz.Score > 0 {
+		nz.Ignored, nz.AutoIgnored = 0, false
+	}
+	m.nzbs[nz.Id] = nz
+	return true, nil
+}
+
+func (m *MemoryStore) Release(guid string) (NZB, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	nz, ok := m.release(guid)
+	if !ok {
+		return nz, ErrNotFound
+	}
+	return nz, nil
+}
+
+func (m *MemoryStore) Releases(movieid int64) []NZB {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.releases(func(nz NZB) bool { return nz.MovieId == movieid }, func(a, b NZB) bool {
+		if a.Ignored != b.Ignored {
+			return a.Ignored < b.Ignored
+		}
+		return a.Score > b.Score
+	})
+}
+
+func (m *MemoryStore) AllReleases() []NZB {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.releases(func(nz NZB) bool { return true }, oldestFirst)
+}
+
+func (m *MemoryStore) ReleasesFrom(group string, indexer string) []NZB {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.releases(func(nz NZB) bool {
+		return (group != "" && nz.Group == group) || (indexer != "" && nz.Indexer == indexer)
+	}, oldestFirst)
+}
+
+func (m *MemoryStore) SetReleaseScores(nzbs []NZB) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, scored := range nzbs {
+		nz, ok := m.nzbs[scored.Id]
+		if !ok {
+			continue
+		}
+		nz.Release, nz.Quality = scored.Release, scored.Quality
+		nz.Score, nz.ScoreDetail = scored.Score, scored.ScoreDetail
+		switch {
+		case nz.Score <= 0 && nz.Ignored == 0:
+			nz.Ignored, nz.AutoIgnored = 1, true
+		case nz.Score > 0 && nz.AutoIgnored:
+			nz.Ignored, nz.AutoIgnored = 0, false
+		}
+		m.nzbs[nz.Id] = nz
+	}
+	return nil
+}
+
+// apply f to nzb guid if we have it
+func (m *MemoryStore) updateRelease(guid string, f func(nz *NZB)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if nz, ok := m.nzbs[guid]; ok {
+		f(&nz)
+		m.nzbs[guid] = nz
+	}
+}
+
+func (m *MemoryStore) SetReleaseFlags(guid string, grabflag int, ignoreflag int) {
+	m.updateRelease(guid, func(nz *NZB) { nz.Grabbed, nz.Ignored, nz.AutoIgnored = grabflag, ignoreflag, false })
+}
+
+func (m *MemoryStore) SetReleaseSeeders(guid string, seeders int, peers int) {
+	m.updateRelease(guid, func(nz *NZB) { nz.Seeders, nz.Peers = seeders, peers })
+}
+
+func (m *MemoryStore) SetReleaseId(guid string, normtitle string, releaseid string) error {
+	m.updateRelease(guid, func(nz *NZB) { nz.NormTitle, nz.ReleaseId = normtitle, releaseid })
+	return nil
+}
+
+func (m *MemoryStore) ReleasesNamed(movieid int64, normtitle string) []NZB {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.releases(func(nz NZB) bool {
+		return nz.MovieId == movieid && nz.NormTitle == normtitle && nz.ReleaseId != ""
+	}, oldestFirst)
+}
+
+func (m *MemoryStore) UngroupedReleases() []NZB {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.releases(func(nz NZB) bool { return nz.ReleaseId == "" }, oldestFirst)
+}
+
+func (m *MemoryStore) SiblingSources(guid string) (guids []string) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	n, ok := m.nzbs[guid]
+	if !ok || n.ReleaseId == "" || !m.movies[n.MovieId].Archived.IsZero() {
+		return nil
+	}
+	siblings := m.releases(func(s NZB) bool {
+		return s.MovieId == n.MovieId && s.ReleaseId == n.ReleaseId && s.Id != n.Id &&
+			s.Grabbed == 0 && s.Ignored == 0 && s.Score > 0
+	}, func(a, b NZB) bool { return a.Score > b.Score })
+	for _, s := range siblings {
+		guids = append(guids, s.Id)
+	}
+	return guids
+}
+
+func (m *MemoryStore) GrabCandidates(protocols []string, upgrades bool) (gbs []Grabbable) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	wanted := make(map[string]bool)
+	for _, p := range protocols {
+		wanted[p] = true
+	}
+	downloading := make(map[int64]bool)
+	for guid := range m.downloads {
+		if nz, ok := m.nzbs[guid]; ok {
+			downloading[nz.MovieId] = true
+		}
+	}
+	nzbs := m.releases(func(nz NZB) bool {
+		mv := m.movies[nz.MovieId]
+		return mv.Archived.IsZero() && (mv.Grabbed == 0 || (mv.DlGuid != "" && upgrades && !downloading[mv.Id])) &&
+			nz.Score > 0 && nz.Grabbed == 0 && nz.Ignored == 0 && wanted[nz.Protocol]
+	}, func(a, b NZB) bool {
+		if a.MovieId != b.MovieId {
+			return a.MovieId < b.MovieId
+		}
+		return a.Score > b.Score
+	})
+	for _, nz := range nzbs {
+		mv := m.movies[nz.MovieId]
+		gbs = append(gbs, Grabbable{MovieId: nz.MovieId, MovieTitle: mv.Title, Id: nz.Id, Link: nz.Link, Quality: nz.Quality,
+			Score: nz.Score, Profile: mv.Profile, MovieGrabbed: mv.Grabbed, DlQuality: mv.DlQuality})
+	}
+	return gbs
+}
+
+func (m *MemoryStore) Downloads(dlmethod string) (dls []Downloads) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for guid, dl := range m.downloads {
+		nz, ok := m.release(guid)
+		if !ok || (dlmethod != "" && dl.DlMethod != dlmethod) {
+			continue
+		}
+		dl.Nicename, dl.Title, dl.MovieID = nz.MovieName, nz.Title, nz.MovieId
+		dls = append(dls, dl)
+	}
+	sort.Slice(dls, func(i, j int) bool { return dls[i].Added.Before(dls[j].Added) })
+	return dls
+}
+
+func (m *MemoryStore) AddDownload(dlid string, guid string, method string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.downloads[guid]; ok {
+		log.Printf("AddDownload:Already have %s", guid)
+		return
+	}
+	m.downloads[guid] = Downloads{Guid: guid, DlId: dlid, DlMethod: method, Status: DLStatusQueued, Added: time.Now()}
+}
+
+func (m *MemoryStore) UpdateDownload(guid string, st DownloadState, progressed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	dl, ok := m.downloads[guid]
+	if !ok {
+		return
+	}
+	dl.Percentage, dl.Status, dl.MBLeft, dl.TimeLeft = st.Percentage, st.Status, st.MBLeft, st.TimeLeft
+	if progressed {
+		dl.LastProgress = time.Now()
+	}
+	m.downloads[guid] = dl
+}
+
+func (m *MemoryStore) RemoveDownload(guid string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.downloads, guid)
+}
+
+func (m *MemoryStore) LogEvent(movieid int64, kind string, guid string, by string, detail string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lastid++
+	m.events = append(m.events, Event{Id: m.lastid, When: time.Now(), MovieId: movieid, Kind: kind, Guid: guid, By: by, Detail: detail})
+}
+
+func (m *MemoryStore) Events(f EventFilter) (evs []Event) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if f.Limit <= 0 {
+		f.Limit = EventLimit
+	}
+	since := time.Now().AddDate(0, 0, -f.Days)
+	search := strings.ToLower(f.Search)
+	//appended in order, so newest are at the end
+	for i := len(m.events) - 1; i >= 0 && len(evs) < f.Limit; i-- {
+		ev := m.events[i]
+		ev.MovieTitle = m.movies[ev.MovieId].Title
+		switch {
+		case f.MovieId != 0 && ev.MovieId != f.MovieId,
+			f.Kind != "" && ev.Kind != f.Kind,
+			f.By != "" && !strings.HasPrefix(strings.ToLower(ev.By), strings.ToLower(f.By)),
+			search != "" && !strings.Contains(strings.ToLower(ev.MovieTitle), search) && !strings.Contains(strings.ToLower(ev.Detail), search),
+			f.Days > 0 && ev.When.Before(since):
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func (m *MemoryStore) ScoreRules() (rules []ScoreRule) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, config := range []bool{true, false} {
+		for _, sr := range m.rules {
+			if sr.FromConfig == config {
+				rules = append(rules, sr)
+			}
+		}
+	}
+	return rules
+}
+
+// a copy of the rule as the database keeps it, lock held
+func (m *MemoryStore) addRule(sr ScoreRule, fromconfig bool) {
+	m.lastid++
+	m.rules = append(m.rules, ScoreRule{Id: m.lastid, Name: sr.Name, Field: sr.Field, Pattern: sr.Pattern,
+		Weight: sr.Weight, Reject: sr.Reject, FromConfig: fromconfig})
+}
+
+func (m *MemoryStore) AddScoreRule(sr ScoreRule) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.addRule(sr, false)
+	return true
+}
+
+func (m *MemoryStore) DeleteScoreRule(id int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, sr := range m.rules {
+		if sr.Id == id && !sr.FromConfig {
+			m.rules = append(m.rules[:i], m.rules[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (m *MemoryStore) ReplaceConfigRules(rules []ScoreRule) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var kept []ScoreRule
+	for _, sr := range m.rules {
+		if !sr.FromConfig {
+			kept = append(kept, sr)
+		}
+	}
+	m.rules = kept
+	for _, sr := range rules {
+		m.addRule(sr, true)
+	}
+	return nil
+}
+
+func (m *MemoryStore) SourceStats(table string) (list []SourceStats) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, ss := range m.stats[table] {
+		list = append(list, ss)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.Policy == "") != (b.Policy == "") {
+			return a.Policy != ""
+		}
+		if a.Failed-a.Completed != b.Failed-b.Completed {
+			return a.Failed-a.Completed > b.Failed-b.Completed
+		}
+		return a.Name < b.Name
+	})
+	return list
+}
+
+// apply f to the stats for name, adding them if create. Lock held.
+func (m *MemoryStore) updateStats(table string, name string, create bool, f func(ss *SourceStats)) {
+	stats, ok := m.stats[table]
+	if !ok {
+		log.Printf("MemoryStore:No stats table %s", table)
+		return
+	}
+	key := strings.ToLower(name)
+	ss, ok := stats[key]
+	if !ok && !create {
+		return
+	}
+	if !ok {
+		ss.Name = name
+	}
+	f(&ss)
+	stats[key] = ss
+}
+
+func (m *MemoryStore) RecordSourceResult(table string, name string, completed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.updateStats(table, name, true, func(ss *SourceStats) {
+		if completed {
+			ss.Completed++
+		} else {
+			ss.Failed++
+			ss.LastFailed = time.Now()
+		}
+	})
+}
+
+func (m *MemoryStore) SetGroupPolicy(name string, policy string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.updateStats(StatsGroups, name, true, func(ss *SourceStats) { ss.Policy = policy })
+}
+
+func (m *MemoryStore) ResetSourceStats(table string, name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.updateStats(table, name, false, func(ss *SourceStats) {
+		ss.Failed, ss.Completed, ss.LastFailed = 0, 0, time.Time{}
+	})
+}
@@ -0,0 +1,491 @@
+//migratestuff.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// A Migration takes the database from Version-1 to Version. They run
+// in order, each in its own transaction along with the schema_version
+// row that records it, so a failure leaves the database as it was.
+// Never change one that has shipped, add another on the end.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *Tx) error
+}
+
+var Migrations = []Migration{
+	{1, "initial tables", func(tx *Tx) error {
+		return execAll(tx, `
+		create table if not exists movies(
+			id integer not null primary key,
+			title text,
+			coverurl text,
+			grabbed integer
+		)`, `
+		create table if not exists nzbs(
+			id text not null primary key,
+			movieid integer not null,
+			title text,
+			link text,
+			score real,
+			size real,
+			grabs integer,
+			usenetdate date,
+			grabbed integer,
+			ignored integer
+		)`, `
+		create table if not exists downloads(
+			id int primary key,
+			guid text not null,
+			dlmethod text not null,
+			dlid text not null,
+			percentage int,
+			status int
+		)`)
+	}},
+	{2, "indexers and torrents", func(tx *Tx) error {
+		return addColumns(tx, "nzbs",
+			"protocol text not null default 'usenet'",
+			"indexer text not null default ''",
+			"seeders integer not null default 0",
+			"peers integer not null default 0",
+			"infohash text not null default ''",
+			"magnet text not null default ''")
+	}},
+	{3, "download progress", func(tx *Tx) error {
+		return addColumns(tx, "downloads",
+			"mbleft real",
+			"timeleft text",
+			"added timestamp",
+			"lastprogress timestamp")
+	}},
+	{4, "parsed releases", func(tx *Tx) error {
+		return addColumns(tx, "nzbs",
+			"year integer not null default 0",
+			"resolution text not null default ''",
+			"source text not null default ''",
+			"codec text not null default ''",
+			"hdr text not null default ''",
+			"audio text not null default ''",
+			"channels text not null default ''",
+			"edition text not null default ''",
+			"languages text not null default ''",
+			"proper integer not null default 0",
+			"repack integer not null default 0",
+			"releasegroup text not null default ''",
+			"quality text not null default ''")
+	}},
+	{5, "quality profiles and upgrades", func(tx *Tx) error {
+		err := addColumns(tx, "movies",
+			"profile text not null default ''",
+			"dlguid text not null default ''",
+			"dltitle text not null default ''",
+			"dlquality text not null default ''",
+			"dlscore real not null default 0")
+		if err != nil {
+			return err
+		}
+		return execAll(tx, `
+		create table if not exists upgrades(
+			movieid integer not null,
+			oldguid text,
+			oldtitle text,
+			oldquality text,
+			oldscore real,
+			newguid text,
+			newtitle text,
+			newquality text,
+			newscore real,
+			upgraded timestamp
+		)`)
+	}},
+	{6, "score breakdown", func(tx *Tx) error {
+		return addColumns(tx, "nzbs", "scoredetail text not null default ''")
+	}},
+	{7, "scoring rules", func(tx *Tx) error {
+		return execAll(tx, `
+		create table if not exists scorerules(
+			id integer not null primary key,
+			name text not null default '',
+			field text not null default 'title',
+			pattern text not null,
+			weight real not null default 0,
+			reject integer not null default 0,
+			fromconfig integer not null default 0
+		)`)
+	}},
+	{8, "movie runtime", func(tx *Tx) error {
+		return addColumns(tx, "movies", "runtime integer not null default 0")
+	}},
+	{9, "group and indexer history", func(tx *Tx) error {
+		return execAll(tx, `
+		create table if not exists groupstats(
+			name text not null primary key collate nocase,
+			failed integer not null default 0,
+			completed integer not null default 0,
+			policy text not null default '',
+			lastfailed timestamp
+		)`, `
+		create table if not exists indexerstats(
+			name text not null primary key collate nocase,
+			failed integer not null default 0,
+			completed integer not null default 0,
+			policy text not null default '',
+			lastfailed timestamp
+		)`)
+	}},
+	{10, "duplicate releases", func(tx *Tx) error {
+		err := addColumns(tx, "nzbs",
+			"normtitle text not null default ''",
+			"releaseid text not null default ''")
+		if err != nil {
+			return err
+		}
+		return execAll(tx, "create index if not exists nzbs_normtitle on nzbs(movieid, normtitle)")
+	}},
+	{11, "foreign keys", func(tx *Tx) error {
+		//orphans from before there were foreign keys
+		err := execAll(tx,
+			"delete from nzbs where movieid not in (select id from movies)",
+			"delete from upgrades where movieid not in (select id from movies)",
+			"delete from downloads where guid not in (select id from nzbs)")
+		if err != nil {
+			return err
+		}
+		err = rebuildTable(tx, "nzbs", `
+		create table nzbs_new(
+			id text not null primary key,
+			movieid integer not null references movies(id) on delete cascade,
+			title text,
+			link text,
+			score real,
+			size real,
+			grabs integer,
+			usenetdate date,
+			grabbed integer,
+			ignored integer,
+			protocol text not null default 'usenet',
+			indexer text not null default '',
+			seeders integer not null default 0,
+			peers integer not null default 0,
+			infohash text not null default '',
+			magnet text not null default '',
+			year integer not null default 0,
+			resolution text not null default '',
+			source text not null default '',
+			codec text not null default '',
+			hdr text not null default '',
+			audio text not null default '',
+			channels text not null default '',
+			edition text not null default '',
+			languages text not null default '',
+			proper integer not null default 0,
+			repack integer not null default 0,
+			releasegroup text not null default '',
+			quality text not null default '',
+			scoredetail text not null default '',
+			normtitle text not null default '',
+			releaseid text not null default ''
+		)`, `id, movieid, title, link, score, size, grabs, usenetdate, grabbed, ignored, protocol, indexer, seeders, peers, infohash, magnet,
+			year, resolution, source, codec, hdr, audio, channels, edition, languages, proper, repack, releasegroup, quality, scoredetail, normtitle, releaseid`)
+		if err != nil {
+			return err
+		}
+		err = rebuildTable(tx, "downloads", `
+		create table downloads_new(
+			id int primary key,
+			guid text not null references nzbs(id) on delete cascade,
+			dlmethod text not null,
+			dlid text not null,
+			percentage int,
+			status int,
+			mbleft real,
+			timeleft text,
+			added timestamp,
+			lastprogress timestamp
+		)`, "id, guid, dlmethod, dlid, percentage, status, mbleft, timeleft, added, lastprogress")
+		if err != nil {
+			return err
+		}
+		err = rebuildTable(tx, "upgrades", `
+		create table upgrades_new(
+			movieid integer not null references movies(id) on delete cascade,
+			oldguid text,
+			oldtitle text,
+			oldquality text,
+			oldscore real,
+			newguid text,
+			newtitle text,
+			newquality text,
+			newscore real,
+			upgraded timestamp
+		)`, "movieid, oldguid, oldtitle, oldquality, oldscore, newguid, newtitle, newquality, newscore, upgraded")
+		if err != nil {
+			return err
+		}
+		//the old indexes went with the old tables
+		return execAll(tx,
+			"create index if not exists nzbs_normtitle on nzbs(movieid, normtitle)",
+			"create index if not exists downloads_guid on downloads(guid)",
+			"create index if not exists upgrades_movieid on upgrades(movieid)")
+	}},
+	{12, "watchlist archive", func(tx *Tx) error {
+		return addColumns(tx, "movies", "archived timestamp")
+	}},
+	{13, "events", func(tx *Tx) error {
+		//no foreign key, a purged movie's history stays
+		return execAll(tx, `
+		create table if not exists events(
+			id integer not null primary key,
+			happened timestamp not null,
+			movieid integer not null default 0,
+			kind text not null,
+			guid text not null default '',
+			who text not null default '',
+			detail text not null default ''
+		)`,
+			"create index if not exists events_movieid on events(movieid, happened)",
+			"create index if not exists events_happened on events(happened)")
+	}},
+	{14, "manual movies", func(tx *Tx) error {
+		return addColumns(tx, "movies", "manual integer not null default 0")
+	}},
+	{15, "auto ignored releases", func(tx *Tx) error {
+		err := addColumns(tx, "nzbs", "autoignored integer not null default 0")
+		if err != nil {
+			return err
+		}
+		//best guess for the old ones, failed downloads are grabbed
+		//and ignores from the web mostly score above 0
+		_, err = tx.Exec("update nzbs set autoignored=1 where ignored=1 and grabbed=0 and score<=0")
+		return err
+	}},
+}
+
+// the version this binary brings the database up to
+func LatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// the version the database is at, 0 for a new or pre-migrations one
+func SchemaVersion() (version int, err error) {
+	_, err = db.Exec(db.Dialect.DDL(`create table if not exists schema_version(
+		version integer not null primary key,
+		name text not null default '',
+		applied timestamp
+	)`))
+	if err != nil {
+		return 0, err
+	}
+	err = db.QueryRow("select coalesce(max(version), 0) from schema_version").Scan(&version)
+	return version, err
+}
+
+// run any migrations the database hasn't had, returns the versions
+// before and after. Won't touch a database from a newer binary.
+func MigrateDB() (from int, to int, err error) {
+	from, err = SchemaVersion()
+	if err != nil {
+		return 0, 0, err
+	}
+	latest := LatestSchemaVersion()
+	if from > latest {
+		return from, from, fmt.Errorf("database is schema version %d but this GoGoMovieDL only knows up to %d, upgrade GoGoMovieDL", from, latest)
+	}
+	to = from
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			return from, to, fmt.Errorf("migration %q is version %d, should be %d", m.Name, m.Version, i+1)
+		}
+		if m.Version <= from {
+			continue
+		}
+		err = runMigration(m)
+		if err != nil {
+			return from, to, fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		log.Printf("MigrateDB:Applied %d %s", m.Version, m.Name)
+		to = m.Version
+	}
+	return from, to, nil
+}
+
+// On sqlite foreign keys are off while a migration runs, so a table
+// can be rebuilt without its drop cascading, and checked before
+// committing. The pragma can't be changed inside a transaction, so
+// it's done on a connection of our own around it. Postgres checks
+// as it goes.
+func runMigration(m Migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if db.Dialect == SQLite {
+		_, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF")
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+	}
+
+	tx, err := db.BeginOn(ctx, conn)
+	if err != nil {
+		return err
+	}
+	err = m.Up(tx)
+	if err == nil {
+		err = foreignKeyCheck(tx)
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO schema_version(version, name, applied) VALUES(?,?,?)", m.Version, m.Name, time.Now())
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// error if any rows point at something that isn't there,
+// postgres won't have let them in
+func foreignKeyCheck(tx *Tx) error {
+	var (
+		table  string
+		rowid  sql.NullInt64
+		parent string
+		fkid   int
+		count  int
+	)
+	if tx.Dialect != SQLite {
+		return nil
+	}
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&table, &rowid, &parent, &fkid)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		return fmt.Errorf("%d rows break foreign keys, last in %s referencing %s", count, table, parent)
+	}
+	return rows.Err()
+}
+
+// sqlite can't add constraints to a table, so make a new one with ddl
+// creating table_new, copy columns across and swap it in. Postgres
+// could just alter it but this way both end up the same.
+func rebuildTable(tx *Tx, table string, ddl string, columns string) error {
+	return execAll(tx,
+		ddl,
+		"insert into "+table+"_new("+columns+") select "+columns+" from "+table,
+		"drop table "+table,
+		"alter table "+table+"_new rename to "+table)
+}
+
+func execAll(tx *Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		_, err := tx.Exec(tx.Dialect.DDL(stmt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add "name decl" columns to table, skipping any it already has -
+// databases from before migrations got some of them another way
+func addColumns(tx *Tx, table string, columns ...string) error {
+	have, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if have[strings.ToLower(name)] {
+			continue
+		}
+		_, err = tx.Exec(tx.Dialect.DDL("ALTER TABLE " + table + " ADD COLUMN " + column))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lower case column names of a table
+func tableColumns(tx *Tx, table string) (map[string]bool, error) {
+	var (
+		cid     int
+		name    string
+		ctype   string
+		notnull int
+		dflt    sql.NullString
+		pk      int
+	)
+	columns := make(map[string]bool)
+	if tx.Dialect == Postgres {
+		rows, err := tx.Query("select column_name from information_schema.columns where table_schema=current_schema() and table_name=?", table)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			err = rows.Scan(&name)
+			if err != nil {
+				return nil, err
+			}
+			columns[strings.ToLower(name)] = true
+		}
+		return columns, rows.Err()
+	}
+	rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk)
+		if err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+	return columns, rows.Err()
+}
+
+// GoGoMovieDL migrate - bring the database up to date and say what happened
+func MigrateCommand() error {
+	if MYDBDSN == MemoryDSN {
+		return fmt.Errorf("MYDBDSN is %q, there's no database to migrate", MYDBDSN)
+	}
+	err := OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	from, to, err := MigrateDB()
+	if err != nil {
+		return err
+	}
+	if from == to {
+		fmt.Printf("Database is up to date at schema version %d\n", to)
+	} else {
+		fmt.Printf("Database migrated from schema version %d to %d\n", from, to)
+	}
+	return nil
+}
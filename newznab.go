@@ -0,0 +1,241 @@
+//newznab.go
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// t=caps response
+type NewznabCaps struct {
+	Limits struct {
+		Max     int `xml:"max,attr"`
+		Default int `xml:"default,attr"`
+	} `xml:"limits"`
+	Searching struct {
+		Search      NewznabSearchCap `xml:"search"`
+		MovieSearch NewznabSearchCap `xml:"movie-search"`
+	} `xml:"searching"`
+	Categories []NewznabCategory `xml:"categories>category"`
+}
+
+type NewznabSearchCap struct {
+	Available       string `xml:"available,attr"`
+	SupportedParams string `xml:"supportedParams,attr"`
+}
+
+type NewznabCategory struct {
+	Id      int               `xml:"id,attr"`
+	Name    string            `xml:"name,attr"`
+	Subcats []NewznabCategory `xml:"subcat"`
+}
+
+// returned in place of a feed when the request was bad
+type NewznabError struct {
+	XMLName     xml.Name `xml:"error"`
+	Code        string   `xml:"code,attr"`
+	Description string   `xml:"description,attr"`
+}
+
+// Newznab indexer, anything that speaks the standard newznab api.
+// Torznab is the same api returning torrents, so it's a Newznab
+// with Protocol set to torrent
+type Newznab struct {
+	IndexerName string
+	BaseURL     string //e.g. https://indexer.example/api
+	APIKey      string
+	Categories  string //comma separated, from caps unless set in config
+	Protocol    string //usenet or torrent
+	Caps        *NewznabCaps
+}
+
+// create a Newznab indexer and ask it what it can do. If the caps
+// request fails we carry on with sensible defaults and try again later
+func NewNewznab(name string, baseurl string, APIKey string, categories string) *Newznab {
+	return newNewznab(name, baseurl, APIKey, categories, "usenet")
+}
+
+// create a Torznab indexer, e.g. jackett or prowlarr
+// http://127.0.0.1:9117/api/v2.0/indexers/all/results/torznab/api
+func NewTorznab(name string, baseurl string, APIKey string, categories string) *Newznab {
+	return newNewznab(name, baseurl, APIKey, categories, "torrent")
+}
+
+func newNewznab(name string, baseurl string, APIKey string, categories string, protocol string) *Newznab {
+	nn := &Newznab{IndexerName: name, BaseURL: ReturnNiceNewznabURL(baseurl), APIKey: APIKey, Categories: categories, Protocol: protocol}
+	if nn.IndexerName == "" {
+		nn.IndexerName = nn.BaseURL
+	}
+	err := nn.LoadCaps()
+	if err != nil {
+		log.Printf("NewNewznab:%s:LoadCaps:%v", nn.IndexerName, err)
+	}
+	return nn
+}
+
+//url should be in format http://host:port/api
+func ReturnNiceNewznabURL(AURL string) string {
+	newurl, err := url.Parse(AURL)
+	if err != nil {
+		return AURL
+	}
+	if !strings.HasSuffix(newurl.Path, "/api") {
+		newurl.Path = strings.TrimSuffix(newurl.Path, "/") + "/api"
+	}
+	return newurl.String()
+}
+
+func (nn *Newznab) Name() string {
+	return nn.IndexerName
+}
+
+// get t=caps and work out the movie categories from it
+func (nn *Newznab) LoadCaps() error {
+	caps := new(NewznabCaps)
+	err := nn.API(url.Values{"t": {"caps"}}, caps)
+	if err != nil {
+		return err
+	}
+	nn.Caps = caps
+	if nn.Categories == "" {
+		nn.Categories = caps.MovieCategories()
+	}
+	log.Printf("Newznab:%s:Caps:Categories=%s Max=%d MovieSearch=%s", nn.IndexerName, nn.Categories, caps.Limits.Max, caps.Searching.MovieSearch.SupportedParams)
+	return nil
+}
+
+// comma separated list of the movies category and its
+// subcategories, or the standard 2000 if none are found
+func (caps *NewznabCaps) MovieCategories() string {
+	var cats []string
+	for _, cat := range caps.Categories {
+		if cat.Id/1000 != 2 && !strings.EqualFold(cat.Name, "movies") {
+			continue
+		}
+		cats = append(cats, strconv.Itoa(cat.Id))
+		for _, sub := range cat.Subcats {
+			cats = append(cats, strconv.Itoa(sub.Id))
+		}
+	}
+	if len(cats) == 0 {
+		return "2000"
+	}
+	return strings.Join(cats, ",")
+}
+
+// true if the search type is available and supports the param
+func (sc NewznabSearchCap) Supports(param string) bool {
+	if sc.Available != "yes" {
+		return false
+	}
+	for _, p := range strings.Split(sc.SupportedParams, ",") {
+		if strings.TrimSpace(p) == param {
+			return true
+		}
+	}
+	return false
+}
+
+// the search type for movies, movie-search if the indexer has it
+func (nn *Newznab) movieSearch(param string) string {
+	if nn.Caps == nil || nn.Caps.Searching.MovieSearch.Supports(param) {
+		return "movie"
+	}
+	return "search"
+}
+
+// common query params for release searches
+func (nn *Newznab) searchParams(t string) url.Values {
+	params := url.Values{}
+	params.Add("t", t)
+	params.Add("cat", nn.Categories)
+	params.Add("extended", "1")
+	if nn.Caps != nil && nn.Caps.Limits.Max > 0 {
+		params.Add("limit", strconv.Itoa(nn.Caps.Limits.Max))
+	}
+	return params
+}
+
+func (nn *Newznab) LatestMovies() (*NZBGRSS, error) {
+	if nn.Caps == nil {
+		nn.LoadCaps()
+	}
+	nz := new(NZBGRSS)
+	err := nn.API(nn.searchParams(nn.movieSearch("cat")), nz)
+	return nz, err
+}
+
+func (nn *Newznab) MovieByIMDB(IMDB int64) (*NZBGRSS, error) {
+	if nn.Caps == nil {
+		nn.LoadCaps()
+	}
+	nz := new(NZBGRSS)
+	if nn.Caps != nil && !nn.Caps.Searching.MovieSearch.Supports("imdbid") {
+		//can't search by id, return empty so the caller can try by title
+		return nz, nil
+	}
+	params := nn.searchParams("movie")
+	params.Add("imdbid", fmt.Sprintf("%07d", IMDB))
+	err := nn.API(params, nz)
+	return nz, err
+}
+
+func (nn *Newznab) MovieByTitle(Title string) (*NZBGRSS, error) {
+	if nn.Caps == nil {
+		nn.LoadCaps()
+	}
+	params := nn.searchParams(nn.movieSearch("q"))
+	params.Add("q", Title)
+	nz := new(NZBGRSS)
+	err := nn.API(params, nz)
+	return nz, err
+}
+
+// call the api with params and the api key
+// and unmarshal the result into target
+func (nn *Newznab) API(params url.Values, target interface{}) error {
+	apiurl, err := url.Parse(nn.BaseURL)
+	if err != nil {
+		return err
+	}
+	params.Set("apikey", nn.APIKey)
+	apiurl.RawQuery = params.Encode()
+	err = XMLFromURL(apiurl.String(), target)
+	if nz, ok := target.(*NZBGRSS); ok {
+		nz.Indexer = nn.IndexerName
+		nz.Protocol = nn.Protocol
+	}
+	return err
+}
+
+// get url and unmarshal xml into target, returning
+// newznab error responses as errors
+func XMLFromURL(url string, target interface{}) error {
+	r, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		return fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	nerr := new(NewznabError)
+	if xml.Unmarshal(body, nerr) == nil {
+		return fmt.Errorf("Newznab error %s: %s", nerr.Code, nerr.Description)
+	}
+
+	return xml.Unmarshal(body, target)
+}
@@ -2,22 +2,29 @@
 package main
 
 import (
-	"encoding/xml"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 )
 
 type NZBGRSS struct {
 	Channels struct {
 		NZBGItems []NZBGItem `xml:"item"`
 	} `xml:"channel"`
+	Indexer  string `xml:"-"` //name of the indexer the feed came from
+	Protocol string `xml:"-"` //usenet or torrent
 }
 
+// NZAttribs picks up both newznab:attr and torznab:attr
 type NZBGItem struct {
-	Title     string     `xml:"title"`
-	Link      string     `xml:"link"`
-	PubDate   string     `xml:"pubDate"`
+	Title     string `xml:"title"`
+	Guid      string `xml:"guid"`
+	Link      string `xml:"link"`
+	Size      string `xml:"size"`
+	PubDate   string `xml:"pubDate"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length string `xml:"length,attr"`
+	} `xml:"enclosure"`
 	NZAttribs []NZAttrib `xml:"attr"`
 }
 
@@ -26,40 +33,56 @@ type NZAttrib struct {
 	Value string `xml:"value,attr"`
 }
 
+// NZBGeek indexer, talks to the nzbgeek rss endpoint
+type NZBGeek struct {
+	IndexerName string
+	BaseURL     string
+	APIKey      string
+}
+
+// create an NZBGeek indexer, name defaults to "NZBGeek"
+func NewNZBGeek(name string, APIKey string) *NZBGeek {
+	if name == "" {
+		name = "NZBGeek"
+	}
+	return &NZBGeek{IndexerName: name, BaseURL: "https://api.nzbgeek.info/rss", APIKey: APIKey}
+}
+
+func (ng *NZBGeek) Name() string {
+	return ng.IndexerName
+}
+
+// helper function to download latest movies from NZBGEEK
+func (ng *NZBGeek) LatestMovies() (*NZBGRSS, error) {
+	// https://api.nzbgeek.info/rss?t=2000&dl=1&num=50&r=APIKEY
+	return ng.RSS("t=2000&dl=1")
+}
+
 // helper function to return releases
 // available for a specific movie via imdb id
-func NZBGeekMovieByIMDB(IMDB int64, APIKey string) (*NZBGRSS, error) {
+func (ng *NZBGeek) MovieByIMDB(IMDB int64) (*NZBGRSS, error) {
 	// https://api.nzbgeek.info/rss?dl=1&imdb=ttxxxx&r=APIKEY
-	return NZBGeekRSS(fmt.Sprintf("dl=1&imdb=tt%d", IMDB), APIKey)
+	return ng.RSS(fmt.Sprintf("dl=1&imdb=tt%07d", IMDB))
 }
 
-// helper function to download latest movies from NZBGEEK
-func NZBGeekMovies(APIKey string) (*NZBGRSS, error) {
-	// https://api.nzbgeek.info/rss?t=2000&dl=1&num=50&r=APIKEY
-	return NZBGeekRSS("t=2000&dl=1", APIKey)
+// helper function to return movie releases matching a title
+func (ng *NZBGeek) MovieByTitle(Title string) (*NZBGRSS, error) {
+	// https://api.nzbgeek.info/rss?t=2000&dl=1&q=title&r=APIKEY
+	return ng.RSS(fmt.Sprintf("t=2000&dl=1&q=%s", url.QueryEscape(Title)))
 }
 
 // main function to visit URL with APIKEY appended
 // and return NZBGRSS structure
-func NZBGeekRSS(URL string, APIKey string) (*NZBGRSS, error) {
-	NewURL := fmt.Sprintf("https://api.nzbgeek.info/rss?%s&r=%s", URL, APIKey)
-
-	r, err := http.Get(NewURL)
-	if err != nil {
-		return nil, err
-	}
-	defer r.Body.Close()
+func (ng *NZBGeek) RSS(URL string) (*NZBGRSS, error) {
+	NewURL := fmt.Sprintf("%s?%s&r=%s", ng.BaseURL, URL, ng.APIKey)
 
 	nz := new(NZBGRSS)
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	err = xml.Unmarshal(body, &nz)
+	err := XMLFromURL(NewURL, nz)
 	if err != nil {
 		return nil, err
 	}
+	nz.Indexer = ng.IndexerName
+	nz.Protocol = "usenet"
 
 	return nz, nil
 }
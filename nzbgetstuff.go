@@ -0,0 +1,207 @@
+//nzbgetstuff.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// NZBGet download client, uses the json-rpc api
+type NZBGet struct {
+	ClientName string
+	URL        string
+	Username   string
+	Password   string
+	Category   string
+}
+
+type NZBGetRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     int           `json:"id"`
+}
+
+type NZBGetResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Name    string `json:"name"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+//listgroups output
+type NZBGetGroup struct {
+	NZBID            int    `json:"NZBID"`
+	NZBName          string `json:"NZBName"`
+	Status           string `json:"Status"`
+	FileSizeMB       int    `json:"FileSizeMB"`
+	RemainingSizeMB  int    `json:"RemainingSizeMB"`
+	DownloadedSizeMB int    `json:"DownloadedSizeMB"`
+}
+
+//history output
+type NZBGetHistory struct {
+	NZBID  int    `json:"NZBID"`
+	Name   string `json:"Name"`
+	Status string `json:"Status"`
+}
+
+func NewNZBGet(name string, nzbgeturl string, username string, password string, category string) *NZBGet {
+	if name == "" {
+		name = "NZBGET"
+	}
+	return &NZBGet{ClientName: name, URL: ReturnNiceNZBGetURL(nzbgeturl), Username: username, Password: password, Category: category}
+}
+
+//url should be in format http://host:port/jsonrpc
+func ReturnNiceNZBGetURL(AURL string) string {
+	newurl, err := url.Parse(AURL)
+	if err != nil {
+		return AURL
+	}
+	newurl.Path = "jsonrpc"
+	log.Println("ReturnNiceNZBGetURL - ", newurl.String())
+	return newurl.String()
+}
+
+func (ng *NZBGet) Name() string {
+	return ng.ClientName
+}
+
+func (ng *NZBGet) Protocol() string {
+	return "usenet"
+}
+
+// call method with params and unmarshal the result into target
+func (ng *NZBGet) RPC(method string, params []interface{}, target interface{}) error {
+	body, err := json.Marshal(NZBGetRequest{Method: method, Params: params, Id: 1})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", ng.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ng.Username != "" {
+		req.SetBasicAuth(ng.Username, ng.Password)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return errors.New("NZBGet " + method + ": " + r.Status)
+	}
+
+	nr := new(NZBGetResponse)
+	err = json.NewDecoder(r.Body).Decode(nr)
+	if err != nil {
+		return err
+	}
+	if nr.Error != nil {
+		return errors.New("NZBGet " + method + ": " + nr.Error.Message)
+	}
+	return json.Unmarshal(nr.Result, target)
+}
+
+//Send the NZBLINK url to NZBGet with nicename as Name. Returns NZBID if valid
+func (ng *NZBGet) SendURL(guid string, nzblink string, nicename string) string {
+	log.Printf("NZBGetSendURL:Grabbing:%s:%s:%s", guid, ng.Category, nicename)
+	var nzbid int
+	//append(NZBFilename, Content, Category, Priority, AddToTop, AddPaused, DupeKey, DupeScore, DupeMode, PPParameters)
+	params := []interface{}{nicename + ".nzb", nzblink, ng.Category, 0, false, false, "", 0, "SCORE", []interface{}{}}
+	err := ng.RPC("append", params, &nzbid)
+	if err != nil {
+		log.Print("NZBGetSendURL:", err)
+		return ""
+	}
+	if nzbid <= 0 {
+		log.Print("NZBGetSendURL:AppendFailed:", nzbid)
+		return ""
+	}
+	return strconv.Itoa(nzbid)
+}
+
+// queue and history for everything nzbget knows about, keyed on NZBID
+func (ng *NZBGet) States() (map[string]DownloadState, error) {
+	var groups []NZBGetGroup
+	var history []NZBGetHistory
+
+	err := ng.RPC("listgroups", []interface{}{0}, &groups)
+	if err != nil {
+		return nil, err
+	}
+	err = ng.RPC("history", []interface{}{false}, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]DownloadState)
+	for _, g := range groups {
+		st := DownloadState{Status: DLStatusDownloading, Message: g.Status}
+		switch g.Status {
+		case "QUEUED":
+			st.Status = DLStatusQueued
+		case "PAUSED":
+			st.Status = DLStatusPaused
+		}
+		if g.FileSizeMB > 0 {
+			st.Percentage = 100 * (g.FileSizeMB - g.RemainingSizeMB) / g.FileSizeMB
+		}
+		st.MBLeft = float64(g.RemainingSizeMB)
+		states[strconv.Itoa(g.NZBID)] = st
+	}
+	for _, h := range history {
+		//Status is e.g. SUCCESS/ALL, WARNING/SCRIPT, FAILURE/PAR, DELETED/MANUAL
+		switch strings.SplitN(h.Status, "/", 2)[0] {
+		case "SUCCESS", "WARNING":
+			states[strconv.Itoa(h.NZBID)] = DownloadState{Status: DLStatusCompleted, Percentage: 100, Message: h.Status}
+		case "FAILURE", "DELETED":
+			states[strconv.Itoa(h.NZBID)] = DownloadState{Status: DLStatusFailed, Percentage: 100, Message: h.Status}
+		}
+	}
+	return states, nil
+}
+
+// listgroups and history cover every job nzbget has
+func (ng *NZBGet) TracksAll() bool {
+	return true
+}
+
+// completed downloads are removed from nzbget history, stalled ones
+// from the queue, failed ones are left there so you can see what happened
+func (ng *NZBGet) Remove(nzbid string, status int) bool {
+	switch status {
+	case DLStatusCompleted:
+		return ng.EditQueue("HistoryDelete", nzbid)
+	case DLStatusStalled:
+		return ng.EditQueue("GroupFinalDelete", nzbid)
+	}
+	return true
+}
+
+// run an editqueue command against a single NZBID
+func (ng *NZBGet) EditQueue(command string, nzbid string) bool {
+	var ok bool
+	id, err := strconv.Atoi(nzbid)
+	if err != nil {
+		log.Printf("NZBGetEditQueue:%s:%s:%v", command, nzbid, err)
+		return false
+	}
+	//editqueue(Command, Param, IDs)
+	err = ng.RPC("editqueue", []interface{}{command, "", []int{id}}, &ok)
+	if err != nil {
+		log.Printf("NZBGetEditQueue:%s:%s:%v", command, nzbid, err)
+		return false
+	}
+	return ok
+}
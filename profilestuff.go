@@ -0,0 +1,192 @@
+//profilestuff.go
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// A QualityProfile lists the qualities a movie may be grabbed in,
+// best first. Qualities are patterns matched against QualityName,
+// e.g. "1080p BluRay", "2160p * HDR" or "*". Cutoff is the quality
+// that's good enough, anything ranked at or above it.
+type QualityProfile struct {
+	Name        string
+	Qualities   []string
+	Cutoff      string
+	Sizes       []SizeLimit //checked before DefaultSizeLimits
+	Downloaders []string    //[[DOWNLOADER]] NAMEs to send to, the first one for the release's protocol
+}
+
+// Sizes a quality should be, in Mb per minute of runtime, so a three
+// hour epic and an eighty minute cartoon are judged fairly. Quality
+// is a pattern like the profile Qualities.
+type SizeLimit struct {
+	Quality string
+	Min     float64
+	Max     float64
+}
+
+// used when the profile doesn't have a SIZE for the quality, by resolution.
+// Wide enough for a small encode up to a remux.
+var DefaultSizeLimits = []SizeLimit{
+	{"2160p *", 60, 1000},
+	{"1080p *", 20, 400},
+	{"720p *", 10, 150},
+	{"576p *", 5, 60},
+	{"480p *", 5, 60},
+	{"*", 5, 1000},
+}
+
+var (
+	QualityProfiles  []QualityProfile //from the [[PROFILE]] tables
+	MYDEFAULTPROFILE string           //profile for movies that don't have one
+)
+
+// used when there are no profiles in the config, takes anything
+var AnyProfile = QualityProfile{Name: "Any", Qualities: []string{"*"}, Cutoff: "*"}
+
+// e.g. "1080p BluRay", "2160p WEB-DL HDR" or "Unknown Unknown"
+func QualityName(r Release) string {
+	resolution := r.Resolution
+	if resolution == "" {
+		resolution = "Unknown"
+	}
+	source := r.Source
+	if source == "" {
+		source = "Unknown"
+	}
+	if r.HDR != "" {
+		return resolution + " " + source + " HDR"
+	}
+	return resolution + " " + source
+}
+
+// read the [[PROFILE]] tables from the config
+func ReadProfiles(config *toml.Tree) {
+	QualityProfiles = nil
+
+	tables, _ := config.Get("PROFILE").([]*toml.Tree)
+	for _, t := range tables {
+		qp := QualityProfile{Name: t.GetDefault("NAME", "").(string), Cutoff: t.GetDefault("CUTOFF", "").(string)}
+		qualities, _ := t.GetDefault("QUALITIES", []interface{}{}).([]interface{})
+		for _, q := range qualities {
+			if qs, ok := q.(string); ok {
+				qp.Qualities = append(qp.Qualities, qs)
+			}
+		}
+		downloaders, _ := t.GetDefault("DOWNLOADERS", []interface{}{}).([]interface{})
+		for _, d := range downloaders {
+			if ds, ok := d.(string); ok {
+				qp.Downloaders = append(qp.Downloaders, ds)
+			}
+		}
+		if qp.Name == "" || len(qp.Qualities) == 0 {
+			log.Printf("ReadProfiles:Skipping profile %q, needs a NAME and QUALITIES", qp.Name)
+			continue
+		}
+		if qp.Cutoff == "" {
+			qp.Cutoff = qp.Qualities[0]
+		}
+		sizes, _ := t.Get("SIZE").([]*toml.Tree)
+		for _, st := range sizes {
+			sl := SizeLimit{Quality: st.GetDefault("QUALITY", "").(string), Min: tomlFloat(st, "MIN"), Max: tomlFloat(st, "MAX")}
+			if sl.Quality == "" || (sl.Max > 0 && sl.Max < sl.Min) {
+				log.Printf("ReadProfiles:%s:Skipping SIZE %q %v-%v", qp.Name, sl.Quality, sl.Min, sl.Max)
+				continue
+			}
+			qp.Sizes = append(qp.Sizes, sl)
+		}
+		QualityProfiles = append(QualityProfiles, qp)
+		log.Printf("ReadProfiles:Added:%s:%s cutoff %s", qp.Name, strings.Join(qp.Qualities, ","), qp.Cutoff)
+	}
+
+	MYDEFAULTPROFILE = config.GetDefault("MYDEFAULTPROFILE", "").(string)
+	if MYDEFAULTPROFILE == "" && len(QualityProfiles) > 0 {
+		MYDEFAULTPROFILE = QualityProfiles[0].Name
+	}
+}
+
+// every profile we can offer, the config ones or just Any
+func ProfileList() []QualityProfile {
+	if len(QualityProfiles) == 0 {
+		return []QualityProfile{AnyProfile}
+	}
+	return QualityProfiles
+}
+
+// the named profile, or the default if there isn't one called that
+func ProfileByName(name string) QualityProfile {
+	if name == "" {
+		name = MYDEFAULTPROFILE
+	}
+	for _, qp := range QualityProfiles {
+		if strings.EqualFold(qp.Name, name) {
+			return qp
+		}
+	}
+	for _, qp := range QualityProfiles {
+		if strings.EqualFold(qp.Name, MYDEFAULTPROFILE) {
+			return qp
+		}
+	}
+	return AnyProfile
+}
+
+// position of quality in the profile, 0 is best, -1 if it isn't allowed
+func (qp QualityProfile) Rank(quality string) int {
+	for i, pattern := range qp.Qualities {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(quality)); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// true if quality is allowed and ranked at or above the cutoff
+func (qp QualityProfile) CutoffMet(quality string) bool {
+	rank := qp.Rank(quality)
+	if rank < 0 {
+		return false
+	}
+	cutoff := -1
+	for i, pattern := range qp.Qualities {
+		if strings.EqualFold(pattern, qp.Cutoff) {
+			cutoff = i
+			break
+		}
+	}
+	if cutoff < 0 {
+		//cutoff isn't in the list, so see if it matches the quality directly
+		ok, _ := path.Match(strings.ToLower(qp.Cutoff), strings.ToLower(quality))
+		return ok
+	}
+	return rank <= cutoff
+}
+
+// Mb per minute limits for a quality, from the profile or the defaults.
+// A Max of 0 means no upper limit.
+func (qp QualityProfile) SizeLimits(quality string) SizeLimit {
+	for _, limits := range [][]SizeLimit{qp.Sizes, DefaultSizeLimits} {
+		for _, sl := range limits {
+			if ok, _ := path.Match(strings.ToLower(sl.Quality), strings.ToLower(quality)); ok {
+				return sl
+			}
+		}
+	}
+	return SizeLimit{Quality: "*"}
+}
+
+// toml gives us an int64 for MIN = 20 and a float64 for MIN = 20.5
+func tomlFloat(t *toml.Tree, key string) float64 {
+	switch v := t.GetDefault(key, int64(0)).(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
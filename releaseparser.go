@@ -0,0 +1,213 @@
+//releaseparser.go
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// what we can tell about a release from its title
+type Release struct {
+	Year       int
+	Resolution string //2160p, 1080p, 720p, 576p, 480p
+	Source     string //Remux, BluRay, WEB-DL, WEBRip, HDTV, DVD, SCR, TC, TS, CAM
+	Codec      string //x265, x264, AV1, VC-1, MPEG2, XviD, DivX
+	HDR        string //DV, HDR10+, HDR10, HLG - space separated if more than one
+	Audio      string //e.g. TrueHD Atmos, DTS-HD MA, DD+, AAC
+	Channels   string //e.g. 7.1, 5.1, 2.0
+	Edition    string //e.g. Extended, Director's Cut - comma separated
+	Languages  string //e.g. German, Multi, KORSUB - comma separated
+	Proper     bool
+	Repack     bool
+	Group      string
+}
+
+// a pattern and the value it stands for, checked in order
+type releaseTag struct {
+	re    *regexp.Regexp
+	value string
+}
+
+// patterns are matched against the title in lower case with
+// separators turned into spaces, so \b works between tokens
+func tags(pairs ...string) (rt []releaseTag) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rt = append(rt, releaseTag{regexp.MustCompile(`\b(?:` + pairs[i] + `)\b`), pairs[i+1]})
+	}
+	return rt
+}
+
+var (
+	releaseSeparators = regexp.MustCompile(`[._\[\]\(\)\{\}\-]+`)
+	releaseYear       = regexp.MustCompile(`\b(19[0-9]{2}|20[0-9]{2})\b`)
+	releaseChannels   = regexp.MustCompile(`(?:^|[^0-9])([1-9])\.([0-2])(?:[^0-9]|$)`)
+	releaseGroup      = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\[[^\]]*\])?$`)
+	releaseExtension  = regexp.MustCompile(`(?i)\.(mkv|mp4|avi|nzb|torrent)$`)
+	releaseNotGroup   = regexp.MustCompile(`\b(?:dl|rip|hd|ma|x|ray|[0-9]+p?|x26[45]|h26[45])\b`)
+	releaseRepost     = regexp.MustCompile(`(?i)\brepost\b`)
+	releaseNotAlnum   = regexp.MustCompile(`[^a-z0-9]+`)
+
+	releaseResolutions = tags(
+		`2160p|4k|uhd`, "2160p",
+		`1080p|1080i`, "1080p",
+		`720p`, "720p",
+		`576p`, "576p",
+		`480p`, "480p",
+	)
+	// the nasty ones first, so HDTC isn't taken as HDTV or TS as WEB
+	releaseSources = tags(
+		`cam|camrip|hdcam|hd ?cam`, "CAM",
+		`ts|telesync|hdts|hd ts|pdvd`, "TS",
+		`tc|telecine|hdtc|hd tc`, "TC",
+		`scr|screener|dvdscr|dvd scr|bdscr`, "SCR",
+		`remux|bdremux`, "Remux",
+		`blu ?ray|bdrip|brrip|bd25|bd50|bd`, "BluRay",
+		`web ?rip`, "WEBRip",
+		`web ?dl|webdl|web`, "WEB-DL",
+		`hdtv|pdtv|dsr|hdrip`, "HDTV",
+		`dvdrip|dvd9|dvd5|dvd r|dvd`, "DVD",
+	)
+	releaseCodecs = tags(
+		`x265|h ?265|hevc`, "x265",
+		`x264|h ?264|avc`, "x264",
+		`av1`, "AV1",
+		`vc ?1`, "VC-1",
+		`mpeg ?2`, "MPEG2",
+		`xvid`, "XviD",
+		`divx`, "DivX",
+	)
+	releaseHDR = tags(
+		`dv|dovi|dolby ?vision`, "DV",
+		`hdr10 ?plus|hdr10p`, "HDR10+",
+		`hdr10|hdr`, "HDR10",
+		`hlg`, "HLG",
+	)
+	releaseAudio = tags(
+		`truehd`, "TrueHD",
+		`dts ?hd ?ma|dts ?ma|dts ?hd`, "DTS-HD MA",
+		`dts ?x`, "DTS:X",
+		`dts`, "DTS",
+		`ddp[0-9]*|dd ?plus[0-9]*|eac3|e ac3`, "DD+",
+		`dd[0-9]*|ac3|dolby digital`, "DD",
+		`aac[0-9]*`, "AAC",
+		`flac`, "FLAC",
+		`lpcm|pcm`, "LPCM",
+		`opus`, "Opus",
+		`mp3`, "MP3",
+	)
+	releaseAtmos    = regexp.MustCompile(`\batmos\b`)
+	releaseEditions = tags(
+		`extended|extended cut|extended edition`, "Extended",
+		`directors? ?cut|directors`, "Director's Cut",
+		`unrated`, "Unrated",
+		`uncut`, "Uncut",
+		`theatrical`, "Theatrical",
+		`imax`, "IMAX",
+		`remastered`, "Remastered",
+		`criterion`, "Criterion",
+		`special edition`, "Special Edition",
+	)
+	releaseLanguages = tags(
+		`german|deutsch`, "German",
+		`truefrench|french|vff|vfq`, "French",
+		`spanish|espanol|castellano`, "Spanish",
+		`italian|ita`, "Italian",
+		`dutch|flemish`, "Dutch",
+		`russian|rus`, "Russian",
+		`hindi`, "Hindi",
+		`korean`, "Korean",
+		`japanese`, "Japanese",
+		`chinese|mandarin|cantonese`, "Chinese",
+		`polish|pl`, "Polish",
+		`swedish|swe`, "Swedish",
+		`nordic`, "Nordic",
+		`multi`, "Multi",
+		`dual`, "Dual",
+		`korsub|korsubs`, "KORSUB",
+		`hebsub|hebsubs`, "HEBSUB",
+		`nlsub|nlsubs|nl subs`, "NLSUBS",
+	)
+	releaseProper = regexp.MustCompile(`\bproper\b`)
+	releaseRepack = regexp.MustCompile(`\b(?:repack|rerip)\b`)
+)
+
+// first match wins
+func firstTag(rt []releaseTag, title string) string {
+	for _, t := range rt {
+		if t.re.MatchString(title) {
+			return t.value
+		}
+	}
+	return ""
+}
+
+// every match, comma separated
+func allTags(rt []releaseTag, title string, sep string) string {
+	var found []string
+	for _, t := range rt {
+		if t.re.MatchString(title) {
+			found = append(found, t.value)
+		}
+	}
+	return strings.Join(found, sep)
+}
+
+// pull what we can out of a release title
+// e.g. Movie.Name.2016.EXTENDED.1080p.BluRay.DTS-HD.MA.5.1.x264-GROUP
+func ParseRelease(title string) (r Release) {
+	title = releaseExtension.ReplaceAllString(strings.TrimSpace(title), "")
+
+	//the group is the bit after the last dash, as long as it isn't
+	//the end of something like WEB-DL or DTS-HD
+	if m := releaseGroup.FindStringSubmatch(title); m != nil {
+		if !releaseNotGroup.MatchString(" " + strings.ToLower(m[1]) + " ") {
+			r.Group = m[1]
+		}
+	}
+
+	if m := releaseChannels.FindStringSubmatch(title); m != nil {
+		r.Channels = m[1] + "." + m[2]
+	}
+
+	//+ can't sit next to \b, so DD+ becomes ddplus and HDR10+ hdr10plus
+	clean := strings.Replace(strings.ToLower(title), "+", "plus", -1)
+	clean = " " + releaseSeparators.ReplaceAllString(clean, " ") + " "
+
+	//the last year is the release year, so 2001 A Space Odyssey 1968 gets 1968
+	if years := releaseYear.FindAllString(clean, -1); len(years) > 0 {
+		r.Year, _ = strconv.Atoi(years[len(years)-1])
+	}
+
+	//don't let the title of the movie give us tags, e.g. The Cam Man
+	tagpart := clean
+	if r.Year > 0 {
+		if i := strings.LastIndex(clean, strconv.Itoa(r.Year)); i > 0 {
+			tagpart = clean[i:]
+		}
+	}
+
+	r.Resolution = firstTag(releaseResolutions, tagpart)
+	r.Source = firstTag(releaseSources, tagpart)
+	r.Codec = firstTag(releaseCodecs, tagpart)
+	r.HDR = allTags(releaseHDR, tagpart, " ")
+	r.Audio = firstTag(releaseAudio, tagpart)
+	if releaseAtmos.MatchString(tagpart) {
+		r.Audio = strings.TrimSpace(r.Audio + " Atmos")
+	}
+	r.Edition = allTags(releaseEditions, tagpart, ", ")
+	r.Languages = allTags(releaseLanguages, tagpart, ", ")
+	r.Proper = releaseProper.MatchString(tagpart)
+	r.Repack = releaseRepack.MatchString(tagpart)
+
+	return r
+}
+
+// just the letters and digits of a title, so the same release posted
+// as "Movie.2016.1080p.BluRay-GRP" and "Movie 2016 1080p BluRay-GRP.nzb"
+// or as a repost can be matched up
+func NormaliseTitle(title string) string {
+	title = releaseExtension.ReplaceAllString(title, "")
+	title = releaseRepost.ReplaceAllString(title, "")
+	return releaseNotAlnum.ReplaceAllString(strings.ToLower(title), "")
+}
@@ -0,0 +1,103 @@
+//releaseparser_test.go
+package main
+
+import "testing"
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		title   string
+		want    Release
+		quality string
+	}{
+		{"Blade.Runner.2049.2017.2160p.UHD.BluRay.REMUX.HDR.HEVC.Atmos-EPSiLON",
+			Release{Year: 2017, Resolution: "2160p", Source: "Remux", Codec: "x265", HDR: "HDR10", Audio: "Atmos", Group: "EPSiLON"},
+			"2160p Remux HDR"},
+		{"Dune.Part.Two.2024.2160p.WEB-DL.DDP5.1.Atmos.DV.HDR10.H.265-FLUX",
+			Release{Year: 2024, Resolution: "2160p", Source: "WEB-DL", Codec: "x265", HDR: "DV HDR10", Audio: "DD+ Atmos", Channels: "5.1", Group: "FLUX"},
+			"2160p WEB-DL HDR"},
+		{"Oppenheimer.2023.2160p.WEB-DL.DV.HDR10+.DDP5.1.H.265-GRP",
+			Release{Year: 2023, Resolution: "2160p", Source: "WEB-DL", Codec: "x265", HDR: "DV HDR10+", Audio: "DD+", Channels: "5.1", Group: "GRP"},
+			"2160p WEB-DL HDR"},
+		{"The.Batman.2022.1080p.WEBRip.x264.AAC5.1-YTS",
+			Release{Year: 2022, Resolution: "1080p", Source: "WEBRip", Codec: "x264", Audio: "AAC", Channels: "5.1", Group: "YTS"},
+			"1080p WEBRip"},
+		{"The.Batman.2022.1080p.WEB-DL.DDP5.1.H.264-CMRG",
+			Release{Year: 2022, Resolution: "1080p", Source: "WEB-DL", Codec: "x264", Audio: "DD+", Channels: "5.1", Group: "CMRG"},
+			"1080p WEB-DL"},
+		{"Heat.1995.Directors.Cut.1080p.BluRay.DTS-HD.MA.7.1.x264-DON",
+			Release{Year: 1995, Resolution: "1080p", Source: "BluRay", Codec: "x264", Audio: "DTS-HD MA", Channels: "7.1", Edition: "Director's Cut", Group: "DON"},
+			"1080p BluRay"},
+		{"Movie.Name.2016.EXTENDED.1080p.BluRay.DTS-HD.MA.5.1.x264-GROUP",
+			Release{Year: 2016, Resolution: "1080p", Source: "BluRay", Codec: "x264", Audio: "DTS-HD MA", Channels: "5.1", Edition: "Extended", Group: "GROUP"},
+			"1080p BluRay"},
+		{"Some.Movie.2023.HDCAM.x264-NOGRP",
+			Release{Year: 2023, Source: "CAM", Codec: "x264", Group: "NOGRP"},
+			"Unknown CAM"},
+		{"Some.Movie.2023.720p.HDTS.x264-NOGRP",
+			Release{Year: 2023, Resolution: "720p", Source: "TS", Codec: "x264", Group: "NOGRP"},
+			"720p TS"},
+		{"Some.Movie.2023.TC.720p.x264-NOGRP",
+			Release{Year: 2023, Resolution: "720p", Source: "TC", Codec: "x264", Group: "NOGRP"},
+			"720p TC"},
+		{"Some Movie 2023 HD TC 720p x264",
+			Release{Year: 2023, Resolution: "720p", Source: "TC", Codec: "x264"},
+			"720p TC"},
+		{"Some.Movie.2023.HDTC.1080p.x264-NOGRP",
+			Release{Year: 2023, Resolution: "1080p", Source: "TC", Codec: "x264", Group: "NOGRP"},
+			"1080p TC"},
+		{"Some.Movie.2023.DVDSCR.XviD-NOGRP",
+			Release{Year: 2023, Source: "SCR", Codec: "XviD", Group: "NOGRP"},
+			"Unknown SCR"},
+		{"Alien.1979.PROPER.1080p.BluRay.x264-GRP",
+			Release{Year: 1979, Resolution: "1080p", Source: "BluRay", Codec: "x264", Proper: true, Group: "GRP"},
+			"1080p BluRay"},
+		{"Alien.1979.REPACK.720p.HDTV.x264-GRP",
+			Release{Year: 1979, Resolution: "720p", Source: "HDTV", Codec: "x264", Repack: true, Group: "GRP"},
+			"720p HDTV"},
+		{"Amelie.2001.MULTi.TRUEFRENCH.1080p.BluRay.x264-GRP",
+			Release{Year: 2001, Resolution: "1080p", Source: "BluRay", Codec: "x264", Languages: "French, Multi", Group: "GRP"},
+			"1080p BluRay"},
+		{"Das.Boot.1981.German.DL.1080p.BluRay.x264-GRP",
+			Release{Year: 1981, Resolution: "1080p", Source: "BluRay", Codec: "x264", Languages: "German", Group: "GRP"},
+			"1080p BluRay"},
+		{"Parasite.2019.1080p.WEBRip.x264-RARBG[rartv]",
+			Release{Year: 2019, Resolution: "1080p", Source: "WEBRip", Codec: "x264", Group: "RARBG"},
+			"1080p WEBRip"},
+		{"Parasite.2019.1080p.BluRay.x264-SPARKS.mkv",
+			Release{Year: 2019, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "SPARKS"},
+			"1080p BluRay"},
+		{"2001.A.Space.Odyssey.1968.1080p.BluRay.x264-GRP",
+			Release{Year: 1968, Resolution: "1080p", Source: "BluRay", Codec: "x264", Group: "GRP"},
+			"1080p BluRay"},
+		{"The.Cam.Man.2019.1080p.WEB-DL.H264",
+			Release{Year: 2019, Resolution: "1080p", Source: "WEB-DL", Codec: "x264"},
+			"1080p WEB-DL"},
+	}
+	for _, tt := range tests {
+		got := ParseRelease(tt.title)
+		if got != tt.want {
+			t.Errorf("ParseRelease(%q)\n got %+v\nwant %+v", tt.title, got, tt.want)
+		}
+		if q := QualityName(got); q != tt.quality {
+			t.Errorf("QualityName(%q) = %q, want %q", tt.title, q, tt.quality)
+		}
+	}
+}
+
+func TestNormaliseTitle(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Movie.2016.1080p.BluRay-GRP", "Movie 2016 1080p BluRay-GRP.nzb", true},
+		{"Movie.2016.1080p.BluRay-GRP", "Movie.2016.1080p.BluRay-GRP.REPOST", true},
+		{"Movie_2016_1080p_BluRay-GRP", "movie.2016.1080p.bluray.grp.mkv", true},
+		{"Movie.2016.1080p.BluRay-GRP", "Movie.2016.720p.BluRay-GRP", false},
+		{"Movie.2016.1080p.BluRay-GRP", "Movie.2016.1080p.BluRay-OTHER", false},
+	}
+	for _, tt := range tests {
+		if same := NormaliseTitle(tt.a) == NormaliseTitle(tt.b); same != tt.same {
+			t.Errorf("NormaliseTitle(%q) == NormaliseTitle(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+//rulestuff.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// A ScoreRule adds Weight to the score of any release whose Field
+// matches Pattern, or rejects it outright. Pattern is a case
+// insensitive regex, except for size (Gb) and age (days) where it
+// can also be a comparison like ">8" or "<=2".
+type ScoreRule struct {
+	Id         int64
+	Name       string
+	Field      string
+	Pattern    string
+	Weight     float64
+	Reject     bool
+	FromConfig bool //from a [[RULE]] table, replaced on every start
+
+	re      *regexp.Regexp
+	compare string
+	value   float64
+}
+
+// fields a rule can match on
+var RuleFields = []string{"title", "group", "size", "age", "indexer"}
+
+var (
+	ConfigRules []ScoreRule //from the [[RULE]] tables
+	scoreRules  []ScoreRule //cached from the scorerules table
+	rulesLock   sync.RWMutex
+)
+
+var ruleComparison = regexp.MustCompile(`^\s*(<=|>=|<|>|=)\s*([0-9]+(?:\.[0-9]+)?)\s*$`)
+
+// read the [[RULE]] tables from the config
+func ReadRules(config *toml.Tree) {
+	ConfigRules = nil
+
+	tables, _ := config.Get("RULE").([]*toml.Tree)
+	for _, t := range tables {
+		sr := ScoreRule{
+			Name:       t.GetDefault("NAME", "").(string),
+			Field:      strings.ToLower(t.GetDefault("FIELD", "title").(string)),
+			Pattern:    t.GetDefault("PATTERN", "").(string),
+			Reject:     t.GetDefault("REJECT", false).(bool),
+			FromConfig: true,
+		}
+		sr.Weight = tomlFloat(t, "WEIGHT")
+		err := CompileRule(&sr)
+		if err != nil {
+			log.Printf("ReadRules:Skipping rule %q:%v", sr.Name, err)
+			continue
+		}
+		ConfigRules = append(ConfigRules, sr)
+		log.Printf("ReadRules:Added:%s", sr)
+	}
+}
+
+// check the field and pattern, and get the rule ready for Matches
+func CompileRule(sr *ScoreRule) error {
+	if !WordInList(strings.Join(RuleFields, ","), sr.Field) {
+		return fmt.Errorf("unknown field %q", sr.Field)
+	}
+	sr.Field = strings.ToLower(sr.Field)
+	if sr.Pattern == "" {
+		return errors.New("needs a pattern")
+	}
+	if sr.Name == "" {
+		sr.Name = sr.Field + " " + sr.Pattern
+	}
+	if sr.Field == "size" || sr.Field == "age" {
+		if m := ruleComparison.FindStringSubmatch(sr.Pattern); m != nil {
+			sr.compare = m[1]
+			sr.value, _ = strconv.ParseFloat(m[2], 64)
+			return nil
+		}
+	}
+	re, err := regexp.Compile("(?i)" + sr.Pattern)
+	if err != nil {
+		return err
+	}
+	sr.re = re
+	return nil
+}
+
+// true if the rule's field of nz matches its pattern
+func (sr ScoreRule) Matches(nz NZB) bool {
+	var value string
+	var number float64
+	switch sr.Field {
+	case "title":
+		value = nz.Title
+	case "group":
+		value = nz.Group
+	case "indexer":
+		value = nz.Indexer
+	case "size":
+		number = nz.Size
+		value = fmt.Sprintf("%0.2f", number)
+	case "age":
+		number = time.Since(nz.UsenetDate).Hours() / 24
+		value = fmt.Sprintf("%d", int(number))
+	}
+	switch sr.compare {
+	case "<":
+		return number < sr.value
+	case "<=":
+		return number <= sr.value
+	case ">":
+		return number > sr.value
+	case ">=":
+		return number >= sr.value
+	case "=":
+		return number == sr.value
+	}
+	if sr.re == nil {
+		return false
+	}
+	return sr.re.MatchString(value)
+}
+
+// e.g. "x265 (title x265 +500)" or "CAM (title \bcam\b reject)"
+func (sr ScoreRule) String() string {
+	if sr.Reject {
+		return fmt.Sprintf("%s (%s %s reject)", sr.Name, sr.Field, sr.Pattern)
+	}
+	return fmt.Sprintf("%s (%s %s %+0.0f)", sr.Name, sr.Field, sr.Pattern, sr.Weight)
+}
+
+// the rules GetScore uses
+func CurrentRules() []ScoreRule {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	return scoreRules
+}
+
+// swap in a new set of rules, skipping any that don't compile
+func SetRules(rules []ScoreRule) {
+	var good []ScoreRule
+	for _, sr := range rules {
+		err := CompileRule(&sr)
+		if err != nil {
+			log.Printf("SetRules:Skipping rule %d %q:%v", sr.Id, sr.Name, err)
+			continue
+		}
+		good = append(good, sr)
+	}
+	rulesLock.Lock()
+	scoreRules = good
+	rulesLock.Unlock()
+}
+
+// replace the config rules in the db with the [[RULE]] tables,
+// keeping the ones added from the web, then reload the cache
+func SyncConfigRules(st Store) {
+	err := st.ReplaceConfigRules(ConfigRules)
+	if err != nil {
+		log.Println("SyncConfigRules:", err)
+	}
+	LoadScoreRules(st)
+}
+
+// refresh the rules GetScore uses from the db
+func LoadScoreRules(st Store) {
+	SetRules(st.ScoreRules())
+	log.Printf("LoadScoreRules:%d rules", len(CurrentRules()))
+}
+
+// the rules that match nz, any reject rule first
+func MatchingRules(nz NZB) (matched []ScoreRule) {
+	for _, sr := range CurrentRules() {
+		if sr.Matches(nz) {
+			if sr.Reject {
+				matched = append([]ScoreRule{sr}, matched...)
+			} else {
+				matched = append(matched, sr)
+			}
+		}
+	}
+	return matched
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -23,6 +24,23 @@ type SabHistory struct {
 	} `json:"history"`
 }
 
+//Queue Output, sab gives numbers as strings here
+type SabQueue struct {
+	Queue struct {
+		Slots []SabQueueSlot `json:"slots"`
+	} `json:"queue"`
+}
+
+type SabQueueSlot struct {
+	Nzo_id     string `json:"nzo_id"`
+	Filename   string `json:"filename"`
+	Status     string `json:"status"`
+	Percentage string `json:"percentage"`
+	MB         string `json:"mb"`
+	MBLeft     string `json:"mbleft"`
+	TimeLeft   string `json:"timeleft"`
+}
+
 type SabSlot struct {
 	Id          int    `json:"id"`
 	Name        string `json:"name"`
@@ -33,6 +51,31 @@ type SabSlot struct {
 	Status      string `json:"status"`
 }
 
+// SABnzbd download client
+type SABnzbd struct {
+	ClientName string
+	URL        string
+	APIKey     string
+	Category   string
+}
+
+// create a SABnzbd client, name defaults to SABNZBD which is
+// what older databases have in downloads.dlmethod
+func NewSABnzbd(name string, saburl string, APIKey string, category string) *SABnzbd {
+	if name == "" {
+		name = "SABNZBD"
+	}
+	return &SABnzbd{ClientName: name, URL: ReturnNiceSABURL(saburl), APIKey: APIKey, Category: category}
+}
+
+func (sab *SABnzbd) Name() string {
+	return sab.ClientName
+}
+
+func (sab *SABnzbd) Protocol() string {
+	return "usenet"
+}
+
 //sanitises the passed in url from the config
 func ReturnNiceSABURL(AURL string) string {
 	//url should be in format http://host:port/sabnzbd/api
@@ -88,66 +131,119 @@ func JsonFromURL(url string, target interface{}) error {
 	return json.NewDecoder(r.Body).Decode(target)
 }
 
-func SABParseHistory() {
+// history slots for the downloads we're tracking, keyed on nzo_id
+func (sab *SABnzbd) ParseHistory() (map[string]DownloadState, error) {
 	//http://localhost:8080/sabnzbd/api?apikey=&mode=history&output=json
-	saburl, err := url.Parse(MYSABURL)
+	saburl, err := url.Parse(sab.URL)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 	params := url.Values{}
 	params.Add("output", "json")
-	params.Add("apikey", MYSABAPI)
+	params.Add("apikey", sab.APIKey)
 	params.Add("mode", "history")
 	saburl.RawQuery = params.Encode()
 	sh := new(SabHistory)
 	err = JsonFromURL(saburl.String(), sh)
 	if err != nil {
 		log.Printf("SABParseHistory:%s  %+v", saburl.String(), err)
-		return
+		return nil, err
 	}
 
-	//get downloads from the db
-	dls := DownloadList("SABNZBD")
-	for _, dl := range dls {
-		for _, slots := range sh.History.Slots {
-			if dl.DlId == slots.Nzo_id {
-				//Only care about success or failed status
-				switch slots.Status {
-				case "Failed", "failed":
-					//download failed - mark movie not grabbed,
-					SetMovieGrab(dl.MovieID, 0)
-					//mark nzb grabbed ignored, leave item in list
-					SetNZBGrabIgnore(dl.Guid, 1, 1)
-					//delete download record from db
-					RemoveDownloadFromDB(dl.Guid)
-					log.Printf("SABFailed:Removed %s from downloads table with id %s", dl.Nicename, dl.DlId)
-				case "Completed", "completed":
-					//download completed ok - delete item from list
-					SetMovieGrab(dl.MovieID, 1)
-					SetNZBGrabIgnore(dl.Guid, 1, 0)
-					RemoveDownloadFromDB(dl.Guid)
-					SABRemoveCompleted("history", slots.Nzo_id)
-					log.Printf("SABCompleted:Removed %s from downloads table with id %s /n/n %+v", dl.Nicename, dl.DlId, slots)
-				default:
-					//log.Debugf("SABParseHistory:SlotStatus %s : Msg %s", slots.Status, slots.FailMessage)
-				}
-			}
+	states := make(map[string]DownloadState)
+	for _, slots := range sh.History.Slots {
+		//Only care about success or failed status
+		switch slots.Status {
+		case "Failed", "failed":
+			states[slots.Nzo_id] = DownloadState{Status: DLStatusFailed, Percentage: 100, Message: slots.FailMessage}
+		case "Completed", "completed":
+			states[slots.Nzo_id] = DownloadState{Status: DLStatusCompleted, Percentage: 100}
+		default:
+			//still post processing, e.g. Verifying, Extracting
+			states[slots.Nzo_id] = DownloadState{Status: DLStatusDownloading, Percentage: 100, Message: slots.Status}
+		}
+	}
+	return states, nil
+}
+
+// queue slots, keyed on nzo_id
+func (sab *SABnzbd) ParseQueue() (map[string]DownloadState, error) {
+	//http://localhost:8080/sabnzbd/api?apikey=&mode=queue&output=json
+	saburl, err := url.Parse(sab.URL)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Add("output", "json")
+	params.Add("apikey", sab.APIKey)
+	params.Add("mode", "queue")
+	saburl.RawQuery = params.Encode()
+	sq := new(SabQueue)
+	err = JsonFromURL(saburl.String(), sq)
+	if err != nil {
+		log.Printf("SABParseQueue:%s  %+v", saburl.String(), err)
+		return nil, err
+	}
+
+	states := make(map[string]DownloadState)
+	for _, slot := range sq.Queue.Slots {
+		st := DownloadState{Status: DLStatusDownloading, Message: slot.Status, TimeLeft: slot.TimeLeft}
+		switch slot.Status {
+		case "Queued":
+			st.Status = DLStatusQueued
+		case "Paused":
+			st.Status = DLStatusPaused
 		}
+		st.Percentage, _ = strconv.Atoi(slot.Percentage)
+		st.MBLeft, _ = strconv.ParseFloat(slot.MBLeft, 64)
+		states[slot.Nzo_id] = st
 	}
+	return states, nil
+}
 
+// queue and history together, history wins if a job is in both
+func (sab *SABnzbd) States() (map[string]DownloadState, error) {
+	states, err := sab.ParseQueue()
+	if err != nil {
+		return nil, err
+	}
+	history, err := sab.ParseHistory()
+	if err != nil {
+		return nil, err
+	}
+	for nzoid, st := range history {
+		states[nzoid] = st
+	}
+	return states, nil
+}
+
+// queue and history cover every job sab has
+func (sab *SABnzbd) TracksAll() bool {
+	return true
+}
+
+// completed jobs are removed from sab history, stalled ones from
+// the queue, failed ones are left there so you can see what happened
+func (sab *SABnzbd) Remove(nzoid string, status int) bool {
+	switch status {
+	case DLStatusCompleted:
+		return sab.RemoveCompleted("history", nzoid)
+	case DLStatusStalled:
+		return sab.RemoveCompleted("queue", nzoid)
+	}
+	return true
 }
 
-func SABRemoveCompleted(mode string, nzoid string) bool {
+func (sab *SABnzbd) RemoveCompleted(mode string, nzoid string) bool {
 	//http://localhost:8080/sabnzbd/api?apikey=&mode=history&name=delete&output=json&value=SABnzbd_nzo_urhpjt
-	saburl, err := url.Parse(MYSABURL)
+	saburl, err := url.Parse(sab.URL)
 	if err != nil {
 		log.Print(err)
 		return false
 	}
 	params := url.Values{}
 	params.Add("output", "json")
-	params.Add("apikey", MYSABAPI)
+	params.Add("apikey", sab.APIKey)
 	//restrict mode
 	if mode == "queue" {
 		params.Add("mode", "queue")
@@ -166,30 +262,10 @@ func SABRemoveCompleted(mode string, nzoid string) bool {
 	return SabR.Status
 }
 
-//Grab NZBD and mark database as grabbed or not.
-func SABGrabAndMark(guid string, movid int64) {
-	//Get URL and Nicename from DB
-	URL, NiceName := URLAndTitleFromDB(guid, movid)
-	//Send URL to SAB, returns trackable ID
-	if URL != "" {
-		Nzo_id := SABSendURL(guid, URL, NiceName, MYSABCAT)
-		if Nzo_id != "" {
-			//Mark as grabbed for NZB and Movie
-			SetNZBGrabIgnore(guid, 1, 0)
-			SetMovieGrab(movid, 1)
-			//Add to downloads
-			MarkNZBDownload(Nzo_id, guid, "SABNZBD")
-		} else {
-			//Issue with download, mark as ignored
-			SetNZBGrabIgnore(guid, 0, 1)
-		}
-	}
-}
-
 //Send the NZBLINK url to SAB with nicename as Name. Returns NZO_ID if valid
-func SABSendURL(guid string, nzblink string, nicename string, category string) string {
-	log.Printf("SABSendURL:Grabbing:%s:%s:%s", guid, category, nicename)
-	nzburl, err := url.Parse(MYSABURL)
+func (sab *SABnzbd) SendURL(guid string, nzblink string, nicename string) string {
+	log.Printf("SABSendURL:Grabbing:%s:%s:%s", guid, sab.Category, nicename)
+	nzburl, err := url.Parse(sab.URL)
 	if err != nil {
 		log.Print("SABSendURL:Parse:", err)
 		return ""
@@ -197,11 +273,11 @@ func SABSendURL(guid string, nzblink string, nicename string, category string) s
 	params := url.Values{}
 	params.Add("mode", "addurl")
 	params.Add("output", "json")
-	params.Add("apikey", MYSABAPI)
+	params.Add("apikey", sab.APIKey)
 	params.Add("name", nzblink)
 	params.Add("nzbname", nicename)
-	if category != "" {
-		params.Add("cat", category)
+	if sab.Category != "" {
+		params.Add("cat", sab.Category)
 	}
 	nzburl.RawQuery = params.Encode()
 	SabR := new(SabResponse)
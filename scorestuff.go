@@ -0,0 +1,209 @@
+//scorestuff.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// how a score was arrived at, kept with the nzb so
+// you can see why one release beat another
+type ScoreBreakdown struct {
+	Total     float64  `json:"total"`
+	Size      float64  `json:"size"`      //gaussian on size, 100 at the expected size
+	Expected  float64  `json:"expected"`  //Gb, from the runtime and quality or 4.5 if unknown
+	Age       float64  `json:"age"`       //exponential decay on age, 100 when new
+	Preferred []string `json:"preferred"` //preferred words found in the title
+	Banned    []string `json:"banned"`    //banned words found in the title
+	Words     float64  `json:"words"`     //bonus and penalty for the words
+	Rules     []string `json:"rules"`     //scoring rules that matched
+	RuleScore float64  `json:"rulescore"` //sum of the matched rule weights
+	Script    float64  `json:"script"`    //adjustment from the score script
+	ScriptErr string   `json:"scripterr"` //if the score script failed
+	History   float64  `json:"history"`   //preferred group bonus and failure penalties
+	Sources   []string `json:"sources"`   //what History is made of
+	Rejected  bool     `json:"rejected"`  //auto ignored
+	Reason    string   `json:"reason"`    //why it was rejected
+}
+
+// one line per component, for tooltips
+func (sb ScoreBreakdown) String() string {
+	if sb.Rejected && sb.Size == 0 && sb.Age == 0 {
+		return fmt.Sprintf("Rejected: %s", sb.Reason)
+	}
+	lines := []string{
+		fmt.Sprintf("Size %0.2f (expected %0.2fGb) x Age %0.2f = %0.2f", sb.Size, sb.Expected, sb.Age, sb.Size*sb.Age),
+		fmt.Sprintf("Words %+0.0f", sb.Words),
+	}
+	if len(sb.Preferred) > 0 {
+		lines = append(lines, "Preferred: "+strings.Join(sb.Preferred, ", "))
+	}
+	if len(sb.Banned) > 0 {
+		lines = append(lines, "Banned: "+strings.Join(sb.Banned, ", "))
+	}
+	if len(sb.Rules) > 0 {
+		lines = append(lines, fmt.Sprintf("Rules %+0.0f: %s", sb.RuleScore, strings.Join(sb.Rules, ", ")))
+	}
+	if len(sb.Sources) > 0 {
+		lines = append(lines, fmt.Sprintf("History %+0.0f: %s", sb.History, strings.Join(sb.Sources, ", ")))
+	}
+	if sb.Script != 0 {
+		lines = append(lines, fmt.Sprintf("Script %+0.0f", sb.Script))
+	}
+	if sb.ScriptErr != "" {
+		lines = append(lines, "Script error: "+sb.ScriptErr)
+	}
+	if sb.Rejected {
+		lines = append(lines, "Rejected: "+sb.Reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//Calculate score from nzb title, date and size, plus any scoring
+//rules that match, how the group and indexer have done before and
+//the score script. Torrents are scored the same way but need at
+//least MYMINSEEDERS to be worth having
+func GetScore(nz NZB) (sb ScoreBreakdown) {
+	expected, min, max := ExpectedSize(nz)
+	rules := MatchingRules(nz)
+	for _, sr := range rules {
+		sb.Rules = append(sb.Rules, sr.String())
+		sb.RuleScore += sr.Weight
+	}
+	//a broken script is ignored, the error is kept to show
+	script, scriptreject, err := RunScoreScript(nz)
+	if err != nil {
+		sb.ScriptErr = err.Error()
+	}
+	sb.Script = script
+	history, sources, blocked := HistoryScore(nz)
+	sb.History, sb.Sources = history, sources
+	if blocked != "" {
+		sb.Total = -10000.5
+		sb.Reason = blocked
+	} else if len(rules) > 0 && rules[0].Reject {
+		sb.Total = -10000.3
+		sb.Reason = "Rejected by rule " + rules[0].Name
+	} else if scriptreject != "" {
+		sb.Total = -10000.4
+		sb.Reason = "Rejected by script: " + scriptreject
+	} else if nz.Protocol == "torrent" && nz.Seeders < MYMINSEEDERS {
+		sb.Total = -10000.1
+		sb.Reason = fmt.Sprintf("%d seeders, need %d", nz.Seeders, MYMINSEEDERS)
+	} else if WordInList(MYBANNEDSOURCES, nz.Source) {
+		sb.Total = -10000.2
+		sb.Reason = fmt.Sprintf("Source %s is banned", nz.Source)
+	} else if nz.Size <= min {
+		sb.Total = -10000.7
+		sb.Reason = fmt.Sprintf("%0.2fGb is under %0.2fGb", nz.Size, min)
+	} else if max > 0 && nz.Size > max {
+		sb.Total = -10000.8
+		sb.Reason = fmt.Sprintf("%0.2fGb is over %0.2fGb", nz.Size, max)
+	} else {
+		nzbage := int(time.Since(nz.UsenetDate).Hours() / 24)
+		// calculate score - gaussian distribution on size and exponential decay for age
+		sb.Expected = expected
+		sb.Size = 100 - math.Abs(math.Pow(nz.Size-expected, 2)/(2*math.Pow(expected/3, 2)))
+		sb.Age = math.Pow(math.E, (-1*float64(nzbage))/180) * 100
+		// score is a combination of both - this means that slightly older files
+		// that are closer to the expected size will have a slightly higher score than newer files
+		// that deviate away from this size.
+
+		// Preferred words get a bonus of 500, and banned words a bonus of -10000
+		sb.Preferred = MatchedWords(MYPREFERREDWORDS, nz.Title)
+		sb.Banned = MatchedWords(MYBANNEDWORDS, nz.Title)
+		sb.Words = float64(len(sb.Preferred))*500 - float64(len(sb.Banned))*10000
+		sb.Total = sb.Size*sb.Age + sb.Words + sb.RuleScore + sb.Script + sb.History
+		if len(sb.Banned) > 0 {
+			sb.Reason = "Banned words: " + strings.Join(sb.Banned, ", ")
+		} else if sb.Total <= 0 {
+			sb.Reason = "Score not above zero"
+		}
+	}
+	sb.Rejected = sb.Total <= 0
+	return sb
+}
+
+//Expected size and limits in Gb for a release, from the movie runtime
+//and the Mb per minute limits for its quality. Without a runtime it's
+//the old 4.5Gb, anything over 0.7Gb. A max of 0 is no upper limit.
+func ExpectedSize(nz NZB) (expected float64, min float64, max float64) {
+	if nz.Runtime <= 0 {
+		return 4.5, 0.7, 0
+	}
+	sl := ProfileByName(nz.Profile).SizeLimits(QualityName(nz.Release))
+	runtime := float64(nz.Runtime)
+	min = runtime * sl.Min / 1024
+	max = runtime * sl.Max / 1024
+	switch {
+	case sl.Min > 0 && sl.Max > 0:
+		//geometric mean, the limits are wide enough that
+		//halfway would put an encode against a remux
+		expected = runtime * math.Sqrt(sl.Min*sl.Max) / 1024
+	case sl.Max > 0:
+		expected = max / 2
+	default:
+		expected = min * 2
+	}
+	if expected <= 0 {
+		expected = 4.5
+	}
+	return expected, min, max
+}
+
+//returns count of comma separated words in instring
+func WordsInString(words string, instring string) (count int) {
+	return len(MatchedWords(words, instring))
+}
+
+//returns the comma separated words that are in instring
+func MatchedWords(words string, instring string) (matched []string) {
+	splitwords := strings.Split(words, ",")
+	for _, word := range splitwords {
+		if word != "" && strings.Contains(strings.ToLower(instring), strings.ToLower(word)) {
+			matched = append(matched, word)
+		}
+	}
+	return matched
+}
+
+//true if word is one of the comma separated words, ignoring case
+func WordInList(words string, word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, w := range strings.Split(words, ",") {
+		if strings.EqualFold(strings.TrimSpace(w), word) {
+			return true
+		}
+	}
+	return false
+}
+
+//scroll through the dataset and update the scores,
+//parsing the titles again in case the parser has improved
+func UpdateNZBScores(st Store) {
+	log.Println("UpdateNZBScores:Begin")
+	// we can always try again later
+	err := RescoreReleases(st, st.AllReleases())
+	if err != nil {
+		log.Println("UpdateNZBScores:SetReleaseScores", err)
+		return
+	}
+	log.Println("UpdateNZBScores:End")
+}
+
+// parse and score nzbs again and save them
+func RescoreReleases(st Store, nzbs []NZB) error {
+	for i, nz := range nzbs {
+		nz.Release = ParseRelease(nz.Title)
+		nz.Quality = QualityName(nz.Release)
+		nz.ScoreDetail = GetScore(nz)
+		nz.Score = nz.ScoreDetail.Total
+		nzbs[i] = nz
+	}
+	return st.SetReleaseScores(nzbs)
+}
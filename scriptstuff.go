@@ -0,0 +1,183 @@
+//scriptstuff.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// A score script is a Starlark file with a function
+//
+//	def score(release, movie):
+//	    if movie.title.endswith("(Anime)") and release.group == "Okay-Subs":
+//	        return 300
+//	    if release.codec == "XviD":
+//	        return "no xvid"
+//	    return 0
+//
+// returning a number to add to the score, a string to reject the
+// release with that reason, or both as a (number, string) tuple.
+// Errors are kept with the score and shown on the Rules page, they
+// don't stop anything.
+
+// stop runaway scripts, plenty for anything sensible
+const ScriptMaxSteps = 100000
+
+var (
+	MYSCORESCRIPT string //Path to a Starlark score script, blank for none
+
+	scriptLock  sync.RWMutex
+	scriptScore starlark.Callable //score() from the script, nil if there isn't one
+	scriptError ScriptError       //last thing that went wrong
+)
+
+// the last load or run error, for the UI
+type ScriptError struct {
+	When    time.Time
+	Message string
+}
+
+// (re)load MYSCORESCRIPT, returns the error too
+// so the UI can say what went wrong
+func LoadScoreScript() error {
+	scriptLock.Lock()
+	defer scriptLock.Unlock()
+
+	scriptScore = nil
+	scriptError = ScriptError{}
+	if MYSCORESCRIPT == "" {
+		return nil
+	}
+
+	src, err := ioutil.ReadFile(MYSCORESCRIPT)
+	if err == nil {
+		thread := &starlark.Thread{Name: "load", Print: scriptPrint}
+		thread.SetMaxExecutionSteps(ScriptMaxSteps)
+		var globals starlark.StringDict
+		globals, err = starlark.ExecFile(thread, MYSCORESCRIPT, src, nil)
+		if err == nil {
+			fn, ok := globals["score"].(starlark.Callable)
+			if !ok {
+				err = errors.New("no score(release, movie) function")
+			} else {
+				globals.Freeze()
+				scriptScore = fn
+			}
+		}
+	}
+	if err != nil {
+		log.Println("LoadScoreScript:", err)
+		scriptError = ScriptError{When: time.Now(), Message: err.Error()}
+		return err
+	}
+	log.Printf("LoadScoreScript:Loaded %s", MYSCORESCRIPT)
+	return nil
+}
+
+// last script error, When is zero if there hasn't been one
+func LastScriptError() ScriptError {
+	scriptLock.RLock()
+	defer scriptLock.RUnlock()
+	return scriptError
+}
+
+// run score() for nz, 0 and no reason if there's no script
+func RunScoreScript(nz NZB) (adjust float64, reject string, err error) {
+	scriptLock.RLock()
+	fn := scriptScore
+	scriptLock.RUnlock()
+	if fn == nil {
+		return 0, "", nil
+	}
+
+	//a script shouldn't be able to take the job down with it
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			adjust, reject = 0, ""
+		}
+		if err != nil {
+			scriptLock.Lock()
+			scriptError = ScriptError{When: time.Now(), Message: fmt.Sprintf("%s: %v", nz.Title, err)}
+			scriptLock.Unlock()
+		}
+	}()
+
+	thread := &starlark.Thread{Name: "score", Print: scriptPrint}
+	thread.SetMaxExecutionSteps(ScriptMaxSteps)
+	res, err := starlark.Call(thread, fn, starlark.Tuple{ReleaseStruct(nz), MovieStruct(nz)}, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	return scriptResult(res)
+}
+
+// the number, string or (number, string) that score() returned
+func scriptResult(res starlark.Value) (adjust float64, reject string, err error) {
+	switch v := res.(type) {
+	case starlark.NoneType:
+		return 0, "", nil
+	case starlark.Int, starlark.Float:
+		f, _ := starlark.AsFloat(v)
+		return f, "", nil
+	case starlark.String:
+		return 0, string(v), nil
+	case starlark.Tuple:
+		if len(v) == 2 {
+			f, ok := starlark.AsFloat(v[0])
+			s, sok := starlark.AsString(v[1])
+			if ok && sok {
+				return f, s, nil
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("score() returned %s, want a number, string or (number, string)", res.Type())
+}
+
+// what the script sees as release
+func ReleaseStruct(nz NZB) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("release"), starlark.StringDict{
+		"title":      starlark.String(nz.Title),
+		"size":       starlark.Float(nz.Size),
+		"age":        starlark.Float(time.Since(nz.UsenetDate).Hours() / 24),
+		"indexer":    starlark.String(nz.Indexer),
+		"protocol":   starlark.String(nz.Protocol),
+		"seeders":    starlark.MakeInt(nz.Seeders),
+		"grabs":      starlark.MakeInt(nz.Grabs),
+		"quality":    starlark.String(QualityName(nz.Release)),
+		"year":       starlark.MakeInt(nz.Year),
+		"resolution": starlark.String(nz.Resolution),
+		"source":     starlark.String(nz.Source),
+		"codec":      starlark.String(nz.Codec),
+		"hdr":        starlark.String(nz.HDR),
+		"audio":      starlark.String(nz.Audio),
+		"channels":   starlark.String(nz.Channels),
+		"edition":    starlark.String(nz.Edition),
+		"languages":  starlark.String(nz.Languages),
+		"proper":     starlark.Bool(nz.Proper),
+		"repack":     starlark.Bool(nz.Repack),
+		"group":      starlark.String(nz.Group),
+	})
+}
+
+// what the script sees as movie
+func MovieStruct(nz NZB) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlark.String("movie"), starlark.StringDict{
+		"id":      starlark.MakeInt64(nz.MovieId),
+		"title":   starlark.String(nz.MovieName),
+		"runtime": starlark.MakeInt(nz.Runtime),
+		"profile": starlark.String(ProfileByName(nz.Profile).Name),
+	})
+}
+
+// print() in a script goes to the log
+func scriptPrint(thread *starlark.Thread, msg string) {
+	log.Printf("ScoreScript:%s:%s", thread.Name, msg)
+}
@@ -0,0 +1,199 @@
+//sqlstuff.go
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The database is sqlite by default, or postgres so several instances
+// can share one, picked by MYDBDSN. Queries are written sqlite style
+// with ? placeholders and DB, Tx and Stmt smooth over the differences.
+
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// where the database is when MYDBDSN isn't set
+const DefaultDBFile = "./GoGoMovieDL.db"
+
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+}
+
+type Stmt struct {
+	*sql.Stmt
+	Dialect Dialect
+}
+
+// driver and dsn for MYDBDSN - a postgres url or key=value string,
+// otherwise a sqlite file
+func DialectFor(dsn string) (Dialect, string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") || strings.Contains(dsn, "dbname=") {
+		return Postgres, dsn
+	}
+	if dsn == "" {
+		dsn = DefaultDBFile
+	}
+	if !strings.Contains(dsn, "?") {
+		dsn += "?mode=rwc&_busy_timeout=5000&_foreign_keys=on"
+	}
+	return SQLite, dsn
+}
+
+// ? to $1, $2... for postgres, leaving any in quotes alone
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	quoted := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// flags are 0/1 integer columns, sqlite's driver does this for us
+func (d Dialect) Args(args []interface{}) []interface{} {
+	if d != Postgres {
+		return args
+	}
+	converted := make([]interface{}, len(args))
+	for i, a := range args {
+		converted[i] = a
+		if b, ok := a.(bool); ok {
+			converted[i] = 0
+			if b {
+				converted[i] = 1
+			}
+		}
+	}
+	return converted
+}
+
+var pgTypes = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`(?i)\binteger not null primary key\b`), "bigserial primary key"},
+	{regexp.MustCompile(`(?i)\bint primary key\b`), "bigserial primary key"},
+	{regexp.MustCompile(`(?i)\binteger\b`), "bigint"},
+	{regexp.MustCompile(`(?i)\breal\b`), "double precision"},
+	{regexp.MustCompile(`(?i)\b(timestamp|date)\b`), "timestamptz"},
+	//group and indexer names are case sensitive on postgres
+	{regexp.MustCompile(`(?i) collate nocase\b`), ""},
+}
+
+// create and alter table statements use sqlite types, postgres
+// gets the nearest equivalent. Auto ids are sqlite's integer
+// primary key, and postgres' real and timestamp lose too much.
+func (d Dialect) DDL(stmt string) string {
+	if d != Postgres {
+		return stmt
+	}
+	start := strings.ToLower(strings.TrimSpace(stmt))
+	if !strings.HasPrefix(start, "create table") && !strings.HasPrefix(start, "alter table") {
+		return stmt
+	}
+	for _, t := range pgTypes {
+		stmt = t.re.ReplaceAllString(stmt, t.with)
+	}
+	return stmt
+}
+
+// true for a unique or foreign key error, e.g. something we already have
+func IsConstraintErr(err error) bool {
+	switch e := err.(type) {
+	case sqlite3.Error:
+		return e.Code == sqlite3.ErrConstraint
+	case *pq.Error:
+		return e.Code.Class() == "23"
+	}
+	return false
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.Dialect.Rebind(query), db.Dialect.Args(args)...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.Dialect.Rebind(query), db.Dialect.Args(args)...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.Dialect.Rebind(query), db.Dialect.Args(args)...)
+}
+
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	stmt, err := db.DB.Prepare(db.Dialect.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{stmt, db.Dialect}, nil
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.Dialect}, nil
+}
+
+// a transaction on a connection we already have, see runMigration
+func (db *DB) BeginOn(ctx context.Context, conn *sql.Conn) (*Tx, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.Dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.Dialect.Rebind(query), tx.Dialect.Args(args)...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.Dialect.Rebind(query), tx.Dialect.Args(args)...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.Dialect.Rebind(query), tx.Dialect.Args(args)...)
+}
+
+func (tx *Tx) Prepare(query string) (*Stmt, error) {
+	stmt, err := tx.Tx.Prepare(tx.Dialect.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{stmt, tx.Dialect}, nil
+}
+
+func (st *Stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return st.Stmt.Exec(st.Dialect.Args(args)...)
+}
@@ -0,0 +1,86 @@
+//storestuff.go
+package main
+
+import "errors"
+
+// Everything GoGoMovieDL keeps goes through a Store - SQLStore for
+// sqlite or postgres, MemoryStore to try the scoring and grabbing
+// without a database file. The scheduler jobs, web server and
+// download code are handed one and don't know which it is.
+type Store interface {
+	MovieStore
+	ReleaseStore
+	DownloadStore
+	EventStore
+	RuleStore
+	StatsStore
+}
+
+// what Movie and Release return for something we haven't got
+var ErrNotFound = errors.New("not found")
+
+// movies from the watchlist
+type MovieStore interface {
+	AddMovie(id int64, title string, runtime int) (added bool, err error) //false and no error if we already have it
+	Movie(id int64) (Movie, error)
+	Movies() []Movie                    //on the watchlist with nzb counts, wanted ones first
+	WantedMovies(upgrades bool) []Movie //ungrabbed, and downloaded ones too if upgrades
+	ArchivedMovies() []Movie            //most recently archived first
+	ArchiveMovie(id int64) bool         //true if it was on the watchlist
+	RestoreMovie(id int64) bool         //true if it was archived
+	DeleteMovie(id int64) bool          //and its releases, downloads and upgrades
+	SetMovieRuntime(id int64, runtime int) bool
+	SetMovieManual(id int64, manual bool) //manual ones aren't archived for being off the watchlist
+	SetMovieProfile(id int64, profile string)
+	SetMovieCover(id int64, coverurl string)
+	SetMovieGrab(id int64, grabflag int)
+	RevertMovieGrab(id int64)
+	SetMovieDownloaded(id int64, guid string) (up Upgrade, upgraded bool)
+	ClearMovieDownloaded(id int64)
+	Upgrades(id int64) []Upgrade //newest first
+}
+
+// nzbs and torrents found for the movies
+type ReleaseStore interface {
+	AddRelease(nz NZB) (added bool, err error) //false and no error if we already have it
+	Release(guid string) (NZB, error)
+	Releases(movieid int64) []NZB                              //ignored last, then best score first
+	AllReleases() []NZB                                        //with the movie's title, runtime and profile, for rescoring
+	ReleasesFrom(group string, indexer string) []NZB           //same as AllReleases, only the ones from either
+	SetReleaseScores(nzbs []NZB) error                         //ignores ones at 0 or less, and un-ignores them when they score more
+	SetReleaseFlags(guid string, grabflag int, ignoreflag int) //by hand or for failing, rescoring won't undo it
+	SetReleaseSeeders(guid string, seeders int, peers int)
+	SetReleaseId(guid string, normtitle string, releaseid string) error
+	ReleasesNamed(movieid int64, normtitle string) []NZB //already grouped, oldest first
+	UngroupedReleases() []NZB                            //oldest first
+	SiblingSources(guid string) []string                 //grabbable copies of the same release, best first, none if the movie is archived
+	GrabCandidates(protocols []string, upgrades bool) []Grabbable
+}
+
+// downloads sent to a download client that haven't finished
+type DownloadStore interface {
+	Downloads(dlmethod string) []Downloads //all of them if dlmethod is blank, oldest first
+	AddDownload(dlid string, guid string, method string)
+	UpdateDownload(guid string, st DownloadState, progressed bool)
+	RemoveDownload(guid string)
+}
+
+type EventStore interface {
+	LogEvent(movieid int64, kind string, guid string, by string, detail string)
+	Events(f EventFilter) []Event //newest first
+}
+
+type RuleStore interface {
+	ScoreRules() []ScoreRule //config ones first
+	AddScoreRule(sr ScoreRule) bool
+	DeleteScoreRule(id int64) bool //only web ones
+	ReplaceConfigRules(rules []ScoreRule) error
+}
+
+// table is StatsGroups or StatsIndexers
+type StatsStore interface {
+	SourceStats(table string) []SourceStats //worst first
+	RecordSourceResult(table string, name string, completed bool)
+	SetGroupPolicy(name string, policy string)
+	ResetSourceStats(table string, name string)
+}
@@ -0,0 +1,403 @@
+//storestuff_test.go
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// run f against a sqlite file and the memory store, so the two
+// Store implementations stay in step
+func eachStore(t *testing.T, f func(t *testing.T, st Store)) {
+	t.Run("sql", func(t *testing.T) {
+		saved := MYDBDSN
+		MYDBDSN = filepath.Join(t.TempDir(), "GoGoMovieDL.db")
+		defer func() { MYDBDSN = saved }()
+		st, err := InitDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		f(t, st)
+	})
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryStore())
+	})
+}
+
+// the config the tests are written against
+func testConfig(t *testing.T) {
+	MYUPGRADES = true
+	MYPREFERREDWORDS = "extended"
+	MYBANNEDWORDS = "xvid"
+	MYBANNEDSOURCES = "CAM,TS,TC,SCR"
+	MYMINSEEDERS = 1
+	MYFAILLIMIT, MYFAILPENALTY, MYGROUPBONUS = 3, 250, 500
+	QualityProfiles = []QualityProfile{{Name: "1080p", Qualities: []string{"1080p BluRay", "1080p WEB-DL", "1080p *", "720p *"}, Cutoff: "1080p BluRay"}}
+	MYDEFAULTPROFILE = "1080p"
+	SetRules(nil)
+	SetSourceStats(nil, nil)
+	t.Cleanup(func() {
+		QualityProfiles, MYDEFAULTPROFILE, DownloadClients = nil, "", nil
+		SetSourceStats(nil, nil)
+	})
+}
+
+// a release for a movie scored the way NZBGRSStoDB does it
+func testRelease(st Store, movieid int64, guid string, title string, size float64) NZB {
+	mv, _ := st.Movie(movieid)
+	nz := NZB{Id: guid, MovieId: movieid, Title: title, Link: "http://indexer/get/" + guid, Size: size, Grabs: 3,
+		UsenetDate: time.Now().Add(-48 * time.Hour).Truncate(time.Second), Protocol: "usenet", Indexer: "one"}
+	nz.Release = ParseRelease(title)
+	nz.Quality = QualityName(nz.Release)
+	nz.Runtime, nz.Profile = mv.Runtime, mv.Profile
+	nz.ScoreDetail = GetScore(nz)
+	nz.Score = nz.ScoreDetail.Total
+	nz.NormTitle = NormaliseTitle(title)
+	return nz
+}
+
+func addRelease(t *testing.T, st Store, nz NZB) NZB {
+	t.Helper()
+	nz.ReleaseId = FindReleaseID(st, nz.MovieId, nz.NormTitle, nz.Size, nz.Id)
+	added, err := st.AddRelease(nz)
+	if !added || err != nil {
+		t.Fatalf("AddRelease(%s) = %v, %v", nz.Id, added, err)
+	}
+	return nz
+}
+
+func addMovie(t *testing.T, st Store, id int64, title string) {
+	t.Helper()
+	added, err := st.AddMovie(id, title, 120)
+	if !added || err != nil {
+		t.Fatalf("AddMovie(%d) = %v, %v", id, added, err)
+	}
+}
+
+// a download client that takes everything and remembers what it got
+type testClient struct {
+	sent    []string
+	removed []string
+}
+
+func (tc *testClient) Name() string     { return "TEST" }
+func (tc *testClient) Protocol() string { return "usenet" }
+func (tc *testClient) SendURL(guid string, link string, nicename string) string {
+	tc.sent = append(tc.sent, guid)
+	return "dl-" + guid
+}
+func (tc *testClient) States() (map[string]DownloadState, error) { return nil, nil }
+func (tc *testClient) TracksAll() bool                           { return false }
+func (tc *testClient) Remove(dlid string, status int) bool {
+	tc.removed = append(tc.removed, dlid)
+	return true
+}
+
+func TestGetScore(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+
+		good := testRelease(st, 1, "good", "Heat.1995.1080p.BluRay.x264-GRP", 10)
+		if good.Score <= 0 || good.ScoreDetail.Reason != "" {
+			t.Errorf("good release scored %v %q", good.Score, good.ScoreDetail.Reason)
+		}
+		if far := testRelease(st, 1, "far", "Heat.1995.1080p.BluRay.x264-GRP", 40); far.Score >= good.Score {
+			t.Errorf("40Gb scored %v, not under %v for the expected size", far.Score, good.Score)
+		}
+		if pref := testRelease(st, 1, "pref", "Heat.1995.EXTENDED.1080p.BluRay.x264-GRP", 10); pref.Score != good.Score+500 {
+			t.Errorf("preferred word scored %v, want %v", pref.Score, good.Score+500)
+		}
+
+		rejects := []struct {
+			title  string
+			size   float64
+			reason string
+		}{
+			{"Heat.1995.HDCAM.x264-GRP", 10, "Source CAM is banned"},
+			{"Heat.1995.1080p.BluRay.x264-GRP", 1, "1.00Gb is under 2.34Gb"},
+			{"Heat.1995.1080p.BluRay.x264-GRP", 50, "50.00Gb is over 46.88Gb"},
+		}
+		for _, r := range rejects {
+			nz := testRelease(st, 1, "x", r.title, r.size)
+			if nz.Score > 0 || nz.ScoreDetail.Reason != r.reason {
+				t.Errorf("%s %vGb scored %v %q, want rejected %q", r.title, r.size, nz.Score, nz.ScoreDetail.Reason, r.reason)
+			}
+		}
+		if nz := testRelease(st, 1, "x", "Heat.1995.1080p.BluRay.XviD-GRP", 10); nz.Score > 0 {
+			t.Errorf("banned word scored %v", nz.Score)
+		}
+		torrent := testRelease(st, 1, "t", "Heat.1995.1080p.BluRay.x264-GRP", 10)
+		torrent.Protocol = "torrent"
+		if sb := GetScore(torrent); sb.Total > 0 {
+			t.Errorf("torrent with no seeders scored %v", sb.Total)
+		}
+
+		//a group that keeps failing, then one that's blocked
+		for i := 0; i < 3; i++ {
+			st.RecordSourceResult(StatsGroups, "GRP", false)
+		}
+		LoadSourceStats(st)
+		if sb := GetScore(good); sb.History != -750 || sb.Total >= good.Score {
+			t.Errorf("failing group history %v, total %v", sb.History, sb.Total)
+		}
+		st.SetGroupPolicy("GRP", GroupBlock)
+		LoadSourceStats(st)
+		if sb := GetScore(good); sb.Total > 0 || sb.Reason != "Group GRP is blocked" {
+			t.Errorf("blocked group scored %v %q", sb.Total, sb.Reason)
+		}
+	})
+}
+
+func TestReleaseMatchesReleases(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+		nz := testRelease(st, 1, "a", "Heat.1995.1080p.BluRay.DTS-HD.MA.5.1.x264-GRP", 10)
+		nz.Seeders, nz.Peers = 4, 2
+		addRelease(t, st, nz)
+
+		one, err := st.Release("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := st.Releases(1)
+		if len(all) != 1 || !reflect.DeepEqual(one, all[0]) {
+			t.Fatalf("Release and Releases differ\n%+v\n%+v", one, all)
+		}
+		if one.Grabs != 3 || one.Seeders != 4 || one.Peers != 2 || !one.UsenetDate.Equal(nz.UsenetDate) ||
+			one.Release != nz.Release || one.MovieName != "Heat" || one.Runtime != 120 || one.NormTitle != nz.NormTitle {
+			t.Errorf("Release(a) = %+v", one)
+		}
+		if _, err := st.Release("nope"); err != ErrNotFound {
+			t.Errorf("Release(nope) = %v", err)
+		}
+	})
+}
+
+func TestGrabbableList(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		for id, title := range map[int64]string{1: "Rank", 2: "Score", 3: "Upgrade", 4: "Cutoff", 5: "Archived", 6: "Unwanted"} {
+			addMovie(t, st, id, title)
+		}
+		//a better ranked quality beats a higher score
+		addRelease(t, st, testRelease(st, 1, "1-720p", "Rank.2016.EXTENDED.720p.BluRay.x264-GRP", 4.5))
+		addRelease(t, st, testRelease(st, 1, "1-1080p", "Rank.2016.1080p.WEB-DL.x264-GRP", 10))
+		//same rank, the higher score wins
+		addRelease(t, st, testRelease(st, 2, "2-near", "Score.2016.1080p.BluRay.x264-GRP", 10))
+		addRelease(t, st, testRelease(st, 2, "2-far", "Score.2016.1080p.BluRay.x264-OTHER", 30))
+		//downloaded short of the cutoff, a better one is an upgrade
+		addRelease(t, st, testRelease(st, 3, "3-web", "Upgrade.2016.1080p.WEB-DL.x264-GRP", 10))
+		addRelease(t, st, testRelease(st, 3, "3-bluray", "Upgrade.2016.1080p.BluRay.x264-GRP", 10))
+		addRelease(t, st, testRelease(st, 3, "3-720p", "Upgrade.2016.720p.BluRay.x264-GRP", 4.5))
+		//downloaded at the cutoff, nothing more to do
+		addRelease(t, st, testRelease(st, 4, "4-bluray", "Cutoff.2016.1080p.BluRay.x264-GRP", 10))
+		addRelease(t, st, testRelease(st, 4, "4-other", "Cutoff.2016.1080p.BluRay.x264-OTHER", 10))
+		//off the watchlist
+		addRelease(t, st, testRelease(st, 5, "5-bluray", "Archived.2016.1080p.BluRay.x264-GRP", 10))
+		st.ArchiveMovie(5)
+		//not a quality the profile wants
+		addRelease(t, st, testRelease(st, 6, "6-2160p", "Unwanted.2016.2160p.BluRay.x265-GRP", 60))
+		for id, guid := range map[int64]string{3: "3-web", 4: "4-bluray"} {
+			st.SetReleaseFlags(guid, 1, 0)
+			st.SetMovieGrab(id, 1)
+			st.SetMovieDownloaded(id, guid)
+		}
+
+		got := make(map[int64]Grabbable)
+		for _, gb := range GrabbableList(st, []string{"usenet"}) {
+			got[gb.MovieId] = gb
+		}
+		want := map[int64]string{1: "1-1080p", 2: "2-near", 3: "3-bluray"}
+		if len(got) != len(want) {
+			t.Errorf("GrabbableList = %+v, want %v", got, want)
+		}
+		for id, guid := range want {
+			if got[id].Id != guid {
+				t.Errorf("movie %d: grabbing %q, want %q", id, got[id].Id, guid)
+			}
+		}
+		if got[1].Upgrade || !got[3].Upgrade {
+			t.Errorf("upgrade flags %v %v", got[1].Upgrade, got[3].Upgrade)
+		}
+
+		//a download in flight holds the upgrade back
+		st.AddDownload("dl-3", "3-bluray", "TEST")
+		for _, gb := range GrabbableList(st, []string{"usenet"}) {
+			if gb.MovieId == 3 {
+				t.Errorf("upgrading %d while it downloads", gb.MovieId)
+			}
+		}
+		st.RemoveDownload("3-bluray")
+
+		MYUPGRADES = false
+		for _, gb := range GrabbableList(st, []string{"usenet"}) {
+			if gb.Upgrade {
+				t.Errorf("upgrade %s with MYUPGRADES off", gb.Id)
+			}
+		}
+		if gbs := GrabbableList(st, []string{"torrent"}); len(gbs) != 0 {
+			t.Errorf("torrents only: %+v", gbs)
+		}
+	})
+}
+
+func TestFailDownloadGrabsSibling(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		tc := &testClient{}
+		DownloadClients = []DownloadClient{tc}
+		addMovie(t, st, 1, "Heat")
+		a := addRelease(t, st, testRelease(st, 1, "a", "Heat.1995.1080p.BluRay.x264-GRP", 10))
+		b := testRelease(st, 1, "b", "Heat 1995 1080p BluRay x264-GRP.nzb", 10.01)
+		b.Indexer = "two"
+		b = addRelease(t, st, b)
+		d := testRelease(st, 1, "d", "Heat.1995.1080p.BluRay.x264-GRP.REPOST", 9.99)
+		d.Indexer = "three"
+		addRelease(t, st, d)
+		addRelease(t, st, testRelease(st, 1, "c", "Heat.1995.EXTENDED.1080p.BluRay.x264-OTHER", 10))
+		if b.ReleaseId != a.Id {
+			t.Fatalf("b grouped under %q, want %q", b.ReleaseId, a.Id)
+		}
+
+		if !GrabAndMark(st, "a", 1, "test") {
+			t.Fatal("GrabAndMark(a) failed")
+		}
+		dls := st.Downloads("TEST")
+		if len(dls) != 1 || dls[0].Guid != "a" {
+			t.Fatalf("downloads %+v", dls)
+		}
+		FailDownload(st, dls[0], "broken")
+
+		if !reflect.DeepEqual(tc.sent, []string{"a", "b"}) {
+			t.Errorf("sent %v, want the other copy after a", tc.sent)
+		}
+		if nz, _ := st.Release("a"); nz.Ignored != 1 || nz.Grabbed != 1 || nz.AutoIgnored {
+			t.Errorf("failed release %+v", nz)
+		}
+		if mv, _ := st.Movie(1); mv.Grabbed != 1 {
+			t.Errorf("movie not grabbed after the sibling was sent")
+		}
+		dls = st.Downloads("TEST")
+		if len(dls) != 1 || dls[0].Guid != "b" {
+			t.Fatalf("downloads %+v", dls)
+		}
+
+		//archived, so the last copy isn't sent
+		st.ArchiveMovie(1)
+		FailDownload(st, dls[0], "broken too")
+		if len(tc.sent) != 2 || len(st.Downloads("")) != 0 {
+			t.Errorf("sent %v, downloads %+v", tc.sent, st.Downloads(""))
+		}
+		if mv, _ := st.Movie(1); mv.Grabbed != 0 {
+			t.Errorf("movie still grabbed")
+		}
+		if GrabAndMark(st, "c", 1, "test") {
+			t.Errorf("grabbed for an archived movie")
+		}
+	})
+}
+
+func TestUpdateNZBScores(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+		stale := testRelease(st, 1, "a", "Heat.1995.1080p.BluRay.x264-GRP", 10)
+		stale.Release, stale.Quality = Release{}, ""
+		addRelease(t, st, stale)
+		addRelease(t, st, testRelease(st, 1, "b", "Heat.1995.1080p.WEB-DL.x264-OTHER", 10))
+		st.SetReleaseFlags("b", 0, 1)
+
+		//the title is parsed again
+		UpdateNZBScores(st)
+		a, _ := st.Release("a")
+		if a.Quality != "1080p BluRay" || a.Group != "GRP" || a.Score <= 0 || a.Ignored != 0 {
+			t.Errorf("rescored %+v", a)
+		}
+
+		//banned now, ignored for it, and back when it isn't
+		MYBANNEDWORDS = "xvid,grp,other"
+		UpdateNZBScores(st)
+		a, _ = st.Release("a")
+		if a.Score > 0 || a.Ignored != 1 || !a.AutoIgnored {
+			t.Errorf("banned %+v", a)
+		}
+		MYBANNEDWORDS = "xvid"
+		UpdateNZBScores(st)
+		a, _ = st.Release("a")
+		b, _ := st.Release("b")
+		if a.Score <= 0 || a.Ignored != 0 || a.AutoIgnored {
+			t.Errorf("unbanned %+v", a)
+		}
+		if b.Score <= 0 || b.Ignored != 1 {
+			t.Errorf("ignored by hand and rescoring took it back %+v", b)
+		}
+	})
+}
+
+func TestArchiveMovie(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		addMovie(t, st, 1, "Heat")
+		if !st.ArchiveMovie(1) {
+			t.Errorf("ArchiveMovie(1) on the watchlist = false")
+		}
+		mv, _ := st.Movie(1)
+		if mv.Archived.IsZero() {
+			t.Fatal("not archived")
+		}
+		if st.ArchiveMovie(1) {
+			t.Errorf("ArchiveMovie(1) already archived = true")
+		}
+		if again, _ := st.Movie(1); !again.Archived.Equal(mv.Archived) {
+			t.Errorf("archived again at %v, was %v", again.Archived, mv.Archived)
+		}
+		if st.ArchiveMovie(2) {
+			t.Errorf("ArchiveMovie(2) we haven't got = true")
+		}
+		if !st.RestoreMovie(1) || !st.ArchiveMovie(1) {
+			t.Errorf("couldn't archive it once restored")
+		}
+	})
+}
+
+func TestRecordDownloadResult(t *testing.T) {
+	eachStore(t, func(t *testing.T, st Store) {
+		testConfig(t)
+		addMovie(t, st, 1, "Heat")
+		addMovie(t, st, 2, "Ronin")
+		//left unparsed, so we can see which were rescored
+		for _, r := range []struct {
+			movieid              int64
+			guid, title, indexer string
+		}{
+			{1, "a", "Heat.1995.1080p.BluRay.x264-GRP", "one"},
+			{2, "b", "Ronin.1998.1080p.BluRay.x264-GRP", "two"},
+			{2, "c", "Ronin.1998.1080p.WEB-DL.x264-OTHER", "one"},
+			{2, "d", "Ronin.1998.1080p.BluRay.x264-OTHER", "two"},
+		} {
+			nz := testRelease(st, r.movieid, r.guid, r.title, 10)
+			nz.Indexer, nz.Quality = r.indexer, ""
+			addRelease(t, st, nz)
+		}
+		//GRP from indexer one keeps failing
+		for i := 0; i < 3; i++ {
+			RecordDownloadResult(st, "a", false)
+		}
+
+		a, _ := st.Release("a")
+		b, _ := st.Release("b")
+		if a.ScoreDetail.History != -1500 || b.ScoreDetail.History != -750 || a.Quality == "" || b.Quality == "" {
+			t.Errorf("group and indexer penalties a %v %q, b %v %q", a.ScoreDetail.History, a.Quality, b.ScoreDetail.History, b.Quality)
+		}
+		if c, _ := st.Release("c"); c.ScoreDetail.History != -750 || c.Quality == "" {
+			t.Errorf("indexer penalty c %v %q", c.ScoreDetail.History, c.Quality)
+		}
+		if d, _ := st.Release("d"); d.Quality != "" {
+			t.Errorf("rescored d from another group and indexer %+v", d)
+		}
+	})
+}
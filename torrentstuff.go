@@ -0,0 +1,443 @@
+//torrentstuff.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// qBittorrent download client, uses the v2 web api. qBittorrent
+// doesn't hand back an id when adding, so each torrent is tagged
+// and the tag is what we keep in downloads.dlid
+type QBittorrent struct {
+	ClientName      string
+	URL             string
+	Username        string
+	Password        string
+	Category        string
+	RemoveCompleted bool
+	client          *http.Client
+	hashes          map[string]string //tag to infohash, filled by States
+	hashlock        sync.Mutex        //the scheduler, watchlist and web all get at hashes
+}
+
+//torrents/info output
+type QBTorrent struct {
+	Hash       string  `json:"hash"`
+	Name       string  `json:"name"`
+	Progress   float64 `json:"progress"`
+	State      string  `json:"state"`
+	Tags       string  `json:"tags"`
+	Eta        int64   `json:"eta"`
+	AmountLeft int64   `json:"amount_left"`
+}
+
+func NewQBittorrent(name string, qburl string, username string, password string, category string, removecompleted bool) *QBittorrent {
+	if name == "" {
+		name = "QBITTORRENT"
+	}
+	jar, _ := cookiejar.New(nil)
+	return &QBittorrent{ClientName: name, URL: strings.TrimSuffix(qburl, "/"), Username: username, Password: password,
+		Category: category, RemoveCompleted: removecompleted, client: &http.Client{Jar: jar}, hashes: make(map[string]string)}
+}
+
+func (qb *QBittorrent) Name() string {
+	return qb.ClientName
+}
+
+func (qb *QBittorrent) Protocol() string {
+	return "torrent"
+}
+
+// tag used to find a torrent again, derived from the guid
+func QBTag(guid string) string {
+	return fmt.Sprintf("gogomoviedl-%x", sha1.Sum([]byte(guid)))[:24]
+}
+
+func (qb *QBittorrent) Login() error {
+	r, err := qb.client.PostForm(qb.URL+"/api/v2/auth/login", url.Values{"username": {qb.Username}, "password": {qb.Password}})
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return errors.New("qBittorrent login: " + r.Status)
+	}
+	return QBAnswer(r, "login")
+}
+
+// qBittorrent says 200 for a bad login or a torrent it won't add,
+// the body is Ok. or Fails.
+func QBAnswer(r *http.Response, what string) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if answer := strings.TrimSpace(string(body)); answer != "Ok." {
+		return fmt.Errorf("qBittorrent %s: %q", what, answer)
+	}
+	return nil
+}
+
+// send a request, logging in again if the session has gone
+func (qb *QBittorrent) Do(newreq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := newreq()
+		if err != nil {
+			return nil, err
+		}
+		r, err := qb.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if r.StatusCode != http.StatusForbidden {
+			return r, nil
+		}
+		r.Body.Close()
+		err = qb.Login()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("qBittorrent: forbidden")
+}
+
+func (qb *QBittorrent) PostForm(path string, params url.Values) error {
+	r, err := qb.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", qb.URL+path, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return errors.New("qBittorrent " + path + ": " + r.Status)
+	}
+	return nil
+}
+
+//Send the torrent url or magnet to qBittorrent. Returns the tag if valid
+func (qb *QBittorrent) SendURL(guid string, link string, nicename string) string {
+	log.Printf("QBSendURL:Grabbing:%s:%s:%s", guid, qb.Category, nicename)
+	tag := QBTag(guid)
+
+	//torrents/add wants multipart/form-data
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("urls", link)
+	mw.WriteField("tags", tag)
+	mw.WriteField("rename", nicename)
+	if qb.Category != "" {
+		mw.WriteField("category", qb.Category)
+	}
+	mw.Close()
+
+	r, err := qb.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", qb.URL+"/api/v2/torrents/add", bytes.NewReader(body.Bytes()))
+		if err == nil {
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		}
+		return req, err
+	})
+	if err != nil {
+		log.Print("QBSendURL:", err)
+		return ""
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		log.Print("QBSendURL:SendReturnedError:", r.Status)
+		return ""
+	}
+	err = QBAnswer(r, "add")
+	if err != nil {
+		log.Print("QBSendURL:", err)
+		return ""
+	}
+	return tag
+}
+
+// everything in our category, keyed on tag
+func (qb *QBittorrent) States() (map[string]DownloadState, error) {
+	var torrents []QBTorrent
+	params := url.Values{}
+	if qb.Category != "" {
+		params.Add("category", qb.Category)
+	}
+	r, err := qb.Do(func() (*http.Request, error) {
+		return http.NewRequest("GET", qb.URL+"/api/v2/torrents/info?"+params.Encode(), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	err = json.NewDecoder(r.Body).Decode(&torrents)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]DownloadState)
+	for _, t := range torrents {
+		st := DownloadState{Status: DLStatusDownloading, Percentage: int(t.Progress * 100), Message: t.State,
+			MBLeft: float64(t.AmountLeft) / (1024 * 1024), TimeLeft: FormatTimeLeft(t.Eta)}
+		switch t.State {
+		case "error", "missingFiles":
+			st.Status = DLStatusFailed
+		case "uploading", "stalledUP", "pausedUP", "stoppedUP", "queuedUP", "forcedUP", "checkingUP":
+			st.Status = DLStatusCompleted
+		case "pausedDL", "stoppedDL":
+			st.Status = DLStatusPaused
+		case "queuedDL", "metaDL":
+			st.Status = DLStatusQueued
+		}
+		for _, tag := range strings.Split(t.Tags, ",") {
+			tag = strings.TrimSpace(tag)
+			if strings.HasPrefix(tag, "gogomoviedl-") {
+				qb.hashlock.Lock()
+				qb.hashes[tag] = t.Hash
+				qb.hashlock.Unlock()
+				states[tag] = st
+			}
+		}
+	}
+	return states, nil
+}
+
+// everything in the category is listed
+func (qb *QBittorrent) TracksAll() bool {
+	return true
+}
+
+// failed and stalled torrents are deleted along with their files,
+// completed ones are left seeding unless REMOVECOMPLETED is set
+func (qb *QBittorrent) Remove(tag string, status int) bool {
+	if status == DLStatusCompleted && !qb.RemoveCompleted {
+		return true
+	}
+	qb.hashlock.Lock()
+	hash, ok := qb.hashes[tag]
+	qb.hashlock.Unlock()
+	if !ok {
+		log.Printf("QBRemove:Unknown tag %s", tag)
+		return false
+	}
+	deletefiles := "false"
+	if status != DLStatusCompleted {
+		deletefiles = "true"
+	}
+	err := qb.PostForm("/api/v2/torrents/delete", url.Values{"hashes": {hash}, "deleteFiles": {deletefiles}})
+	if err != nil {
+		log.Printf("QBRemove:%s:%v", tag, err)
+		return false
+	}
+	qb.hashlock.Lock()
+	delete(qb.hashes, tag)
+	qb.hashlock.Unlock()
+	return true
+}
+
+// Transmission download client, uses the rpc api and
+// keeps the torrent hashString in downloads.dlid
+type Transmission struct {
+	ClientName      string
+	URL             string
+	Username        string
+	Password        string
+	Category        string //set as a label on the torrent
+	RemoveCompleted bool
+	sessionid       string
+	sessionlock     sync.Mutex //the scheduler, watchlist and web all call RPC
+}
+
+type TransmissionRequest struct {
+	Method    string      `json:"method"`
+	Arguments interface{} `json:"arguments"`
+}
+
+type TransmissionResponse struct {
+	Result    string          `json:"result"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type TransmissionTorrent struct {
+	Id            int     `json:"id"`
+	HashString    string  `json:"hashString"`
+	Name          string  `json:"name"`
+	PercentDone   float64 `json:"percentDone"`
+	Status        int     `json:"status"`
+	Error         int     `json:"error"`
+	ErrorString   string  `json:"errorString"`
+	LeftUntilDone int64   `json:"leftUntilDone"`
+	Eta           int64   `json:"eta"`
+}
+
+func NewTransmission(name string, trurl string, username string, password string, category string, removecompleted bool) *Transmission {
+	if name == "" {
+		name = "TRANSMISSION"
+	}
+	return &Transmission{ClientName: name, URL: ReturnNiceTransmissionURL(trurl), Username: username, Password: password,
+		Category: category, RemoveCompleted: removecompleted}
+}
+
+//url should be in format http://host:port/transmission/rpc
+func ReturnNiceTransmissionURL(AURL string) string {
+	newurl, err := url.Parse(AURL)
+	if err != nil {
+		return AURL
+	}
+	newurl.Path = "transmission/rpc"
+	log.Println("ReturnNiceTransmissionURL - ", newurl.String())
+	return newurl.String()
+}
+
+func (tr *Transmission) Name() string {
+	return tr.ClientName
+}
+
+func (tr *Transmission) Protocol() string {
+	return "torrent"
+}
+
+// call method with arguments and unmarshal the result arguments into
+// target. Transmission answers 409 with a session id to use first time.
+func (tr *Transmission) RPC(method string, arguments interface{}, target interface{}) error {
+	body, err := json.Marshal(TransmissionRequest{Method: method, Arguments: arguments})
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest("POST", tr.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		tr.sessionlock.Lock()
+		req.Header.Set("X-Transmission-Session-Id", tr.sessionid)
+		tr.sessionlock.Unlock()
+		if tr.Username != "" {
+			req.SetBasicAuth(tr.Username, tr.Password)
+		}
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if r.StatusCode == http.StatusConflict {
+			tr.sessionlock.Lock()
+			tr.sessionid = r.Header.Get("X-Transmission-Session-Id")
+			tr.sessionlock.Unlock()
+			r.Body.Close()
+			continue
+		}
+		defer r.Body.Close()
+		if r.StatusCode != 200 {
+			return errors.New("Transmission " + method + ": " + r.Status)
+		}
+		trr := new(TransmissionResponse)
+		err = json.NewDecoder(r.Body).Decode(trr)
+		if err != nil {
+			return err
+		}
+		if trr.Result != "success" {
+			return errors.New("Transmission " + method + ": " + trr.Result)
+		}
+		if target == nil {
+			return nil
+		}
+		return json.Unmarshal(trr.Arguments, target)
+	}
+	return errors.New("Transmission " + method + ": no session id")
+}
+
+//Send the torrent url or magnet to Transmission. Returns the hash if valid
+func (tr *Transmission) SendURL(guid string, link string, nicename string) string {
+	log.Printf("TransmissionSendURL:Grabbing:%s:%s:%s", guid, tr.Category, nicename)
+	var added struct {
+		Added     *TransmissionTorrent `json:"torrent-added"`
+		Duplicate *TransmissionTorrent `json:"torrent-duplicate"`
+	}
+	err := tr.RPC("torrent-add", map[string]interface{}{"filename": link}, &added)
+	if err != nil {
+		log.Print("TransmissionSendURL:", err)
+		return ""
+	}
+	t := added.Added
+	if t == nil {
+		t = added.Duplicate
+	}
+	if t == nil || t.HashString == "" {
+		log.Print("TransmissionSendURL:NoHashReturned")
+		return ""
+	}
+	if tr.Category != "" {
+		err = tr.RPC("torrent-set", map[string]interface{}{"ids": []string{t.HashString}, "labels": []string{tr.Category}}, nil)
+		if err != nil {
+			log.Print("TransmissionSendURL:SetLabel:", err)
+		}
+	}
+	return strings.ToLower(t.HashString)
+}
+
+// every torrent transmission has, keyed on hash
+func (tr *Transmission) States() (map[string]DownloadState, error) {
+	var got struct {
+		Torrents []TransmissionTorrent `json:"torrents"`
+	}
+	fields := []string{"id", "hashString", "name", "percentDone", "status", "error", "errorString", "leftUntilDone", "eta"}
+	err := tr.RPC("torrent-get", map[string]interface{}{"fields": fields}, &got)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]DownloadState)
+	for _, t := range got.Torrents {
+		st := DownloadState{Status: DLStatusDownloading, Percentage: int(t.PercentDone * 100), Message: t.ErrorString,
+			MBLeft: float64(t.LeftUntilDone) / (1024 * 1024), TimeLeft: FormatTimeLeft(t.Eta)}
+		switch {
+		case t.Error == 3:
+			//local error, e.g. disk full or files gone
+			st.Status = DLStatusFailed
+		case t.LeftUntilDone == 0 && t.PercentDone >= 1:
+			st.Status = DLStatusCompleted
+		case t.Status == 0:
+			st.Status = DLStatusPaused
+		case t.Status == 3:
+			st.Status = DLStatusQueued
+		}
+		states[strings.ToLower(t.HashString)] = st
+	}
+	return states, nil
+}
+
+// every torrent is listed
+func (tr *Transmission) TracksAll() bool {
+	return true
+}
+
+// failed and stalled torrents are deleted along with their files,
+// completed ones are left seeding unless REMOVECOMPLETED is set
+func (tr *Transmission) Remove(hash string, status int) bool {
+	if status == DLStatusCompleted && !tr.RemoveCompleted {
+		return true
+	}
+	err := tr.RPC("torrent-remove", map[string]interface{}{"ids": []string{hash}, "delete-local-data": status != DLStatusCompleted}, nil)
+	if err != nil {
+		log.Printf("TransmissionRemove:%s:%v", hash, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+//torrentstuff_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// calls from the scheduler and the web at once share the session id,
+// run with -race to see it's guarded
+func TestTransmissionSession(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Transmission-Session-Id") != "session-1" {
+			w.Header().Set("X-Transmission-Session-Id", "session-1")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"result":"success","arguments":{}}`))
+	}))
+	defer ts.Close()
+
+	tr := NewTransmission("", ts.URL, "", "", "", false)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tr.RPC("session-get", nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -7,10 +7,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -23,9 +26,14 @@ var (
 	templates map[string]*template.Template
 )
 
+// The web pages, on whatever Store we were given
+type WebServer struct {
+	st Store
+}
+
 //Show list of all movies
-func MoviesHandler(w http.ResponseWriter, r *http.Request) {
-	mvs := MoviesList(-1)
+func (ws *WebServer) MoviesHandler(w http.ResponseWriter, r *http.Request) {
+	mvs := ws.st.Movies()
 	if mvs == nil {
 		log.Print("Webstuff:MoviesHandler:GetMoviesList:NothingReturned")
 		http.Error(w, "", 500)
@@ -52,7 +60,7 @@ func MoviesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 //Show files available for specific movie
-func MovieHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebServer) MovieHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	MovieId, err := strconv.ParseInt(id, 10, 64)
@@ -61,23 +69,35 @@ func MovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	//moviestruct for passing to template
 	type moviestruct struct {
+		MovieId   int64
 		MovieName string
+		Profile   QualityProfile
+		Profiles  []QualityProfile
 		NZBList   []NZB
+		Download  Movie
+		Upgrades  []Upgrade
+		Events    []Event
 	}
 
-	mv := moviestruct{}
-	mv.NZBList = NzbListByMovie(MovieId, -1, -1)
+	mv := moviestruct{MovieId: MovieId, Profiles: ProfileList()}
+	mv.NZBList = ws.st.Releases(MovieId)
 	if len(mv.NZBList) <= 0 {
 		return
 	}
 
 	mv.MovieName = mv.NZBList[0].MovieName
+	mv.Download, _ = ws.st.Movie(MovieId)
+	mv.Profile = ProfileByName(mv.Download.Profile)
+	mv.Upgrades = ws.st.Upgrades(MovieId)
+	mv.Events = ws.st.Events(EventFilter{MovieId: MovieId})
 
 	//fixup the url
 	for i, mov := range mv.NZBList {
 		//Remember to modify mv, not mov!
 		mv.NZBList[i].GrabURL = fmt.Sprintf("/getnzb/%s/%s/", id, mov.Id)
+		mv.NZBList[i].Rank = mv.Profile.Rank(mov.Quality)
 	}
+	mv.NZBList = GroupSources(mv.NZBList)
 
 	t, ok := templates["MovieTPL"]
 	if !ok {
@@ -96,50 +116,354 @@ func MovieHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//One row per release, with the other copies of it as Sources
+//of the first (best) one
+func GroupSources(nzbs []NZB) (grouped []NZB) {
+	first := make(map[string]int) //releaseid to index in grouped
+	for _, nz := range nzbs {
+		if i, ok := first[nz.ReleaseId]; ok && nz.ReleaseId != "" {
+			grouped[i].Sources = append(grouped[i].Sources, nz)
+			continue
+		}
+		first[nz.ReleaseId] = len(grouped)
+		grouped = append(grouped, nz)
+	}
+	return grouped
+}
+
 //Get all nzbs for a specific movie id
-func RefreshNZBHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebServer) RefreshNZBHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	movid, _ := strconv.ParseInt(id, 10, 64)
-	nz, err := NZBGeekMovieByIMDB(movid, MYAPIKEY)
-	if err != nil {
-		log.Print("RefreshNZBHandler:GetByID:", id, err)
-	} else {
-		NZBGRSStoDB(nz)
-	}
+	mov, _ := ws.st.Movie(movid)
+	count := SearchIndexersForMovie(ws.st, movid, mov.Title)
+	log.Printf("RefreshNZBHandler:%d:%d added", movid, count)
+	ws.st.LogEvent(movid, EventSearched, "", webBy(r), fmt.Sprintf("%d added", count))
 	http.Redirect(w, r, "/", 302)
 }
 
 //Mark Movie ungrabbed
-func MovieUngrabbedHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebServer) MovieUngrabbedHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	movid, _ := strconv.ParseInt(id, 10, 64)
-	SetMovieGrab(movid, 0)
+	ws.st.SetMovieGrab(movid, 0)
+	ws.st.ClearMovieDownloaded(movid)
+	ws.st.LogEvent(movid, EventUngrabbed, "", webBy(r), "")
 	http.Redirect(w, r, "/", 302)
 }
 
 //Set NZB ignored
-func NZBIgnoredHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebServer) NZBIgnoredHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	guid := vars["nzbguid"]
 	flag := vars["flag"]
 	iflag, _ := strconv.Atoi(flag)
-	SetNZBGrabIgnore(guid, 0, iflag)
+	ws.st.SetReleaseFlags(guid, 0, iflag)
+	movid, _ := strconv.ParseInt(id, 10, 64)
+	nz, _ := ws.st.Release(guid)
+	if iflag == 1 {
+		ws.st.LogEvent(movid, EventIgnored, guid, webBy(r), nz.Title)
+	} else {
+		ws.st.LogEvent(movid, EventUnignored, guid, webBy(r), nz.Title)
+	}
+	http.Redirect(w, r, fmt.Sprintf("/%s/", id), 302)
+}
+
+//Set quality profile for a movie
+func (ws *WebServer) MovieProfileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	movid, _ := strconv.ParseInt(id, 10, 64)
+	for _, qp := range ProfileList() {
+		if qp.Name == vars["profile"] {
+			ws.st.SetMovieProfile(movid, qp.Name)
+			ws.st.LogEvent(movid, EventProfile, "", webBy(r), qp.Name)
+			//size limits can be different in the new profile
+			go UpdateNZBScores(ws.st)
+		}
+	}
 	http.Redirect(w, r, fmt.Sprintf("/%s/", id), 302)
 }
 
 //Send NZB and redirect back to movie
-func GrabNZBHandler(w http.ResponseWriter, r *http.Request) {
+func (ws *WebServer) GrabNZBHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	guid := vars["nzbguid"]
 	movid, _ := strconv.ParseInt(id, 10, 64)
-	SABGrabAndMark(guid, movid)
+	GrabAndMark(ws.st, guid, movid, webBy(r))
 	http.Redirect(w, r, fmt.Sprintf("/%s/", id), 302)
 }
 
+//Score breakdown for one nzb as json
+func (ws *WebServer) ScoreHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	guid := vars["nzbguid"]
+	movid, _ := strconv.ParseInt(id, 10, 64)
+	nz, err := ws.st.Release(guid)
+	if err == nil && nz.MovieId != movid {
+		err = ErrNotFound
+	}
+	if err != nil {
+		log.Print("Webstuff:ScoreHandler:", err)
+		http.Error(w, "NotFound", 404)
+		return
+	}
+	sb := nz.ScoreDetail
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(sb)
+	if err != nil {
+		log.Print("Webstuff:ScoreHandler:Encode:", err)
+	}
+}
+
+//Show the scoring rules, with a form to add more
+func (ws *WebServer) RulesHandler(w http.ResponseWriter, r *http.Request) {
+	rulesstruct := struct {
+		Rules       []ScoreRule
+		Fields      []string
+		Script      string
+		ScriptError ScriptError
+	}{ws.st.ScoreRules(), RuleFields, MYSCORESCRIPT, LastScriptError()}
+
+	t, ok := templates["RulesTPL"]
+	if !ok {
+		log.Print("Webstuff:RulesHandler:Parse")
+		http.Error(w, "TemplateDoesntExist", 500)
+		return
+	}
+	err := t.Execute(w, rulesstruct)
+	if err != nil {
+		log.Print("Webstuff:RulesHandler:Execute:", err)
+		http.Error(w, "Boom", 500)
+	}
+}
+
+//Add a scoring rule from the form and rescore everything
+func (ws *WebServer) AddRuleHandler(w http.ResponseWriter, r *http.Request) {
+	sr := ScoreRule{
+		Name:    r.FormValue("name"),
+		Field:   r.FormValue("field"),
+		Pattern: r.FormValue("pattern"),
+		Reject:  r.FormValue("reject") == "1",
+	}
+	sr.Weight, _ = strconv.ParseFloat(r.FormValue("weight"), 64)
+	err := CompileRule(&sr)
+	if err != nil {
+		log.Print("Webstuff:AddRuleHandler:", err)
+		http.Error(w, "Bad rule: "+err.Error(), 400)
+		return
+	}
+	if ws.st.AddScoreRule(sr) {
+		ws.st.LogEvent(0, EventRule, "", webBy(r), "Added "+sr.String())
+		LoadScoreRules(ws.st)
+		go UpdateNZBScores(ws.st)
+	}
+	http.Redirect(w, r, "/rules/", 302)
+}
+
+//Reload the score script after editing it and rescore everything,
+//a script that won't load shows its error on the rules page
+func (ws *WebServer) ReloadScriptHandler(w http.ResponseWriter, r *http.Request) {
+	err := LoadScoreScript()
+	if err != nil {
+		ws.st.LogEvent(0, EventScript, "", webBy(r), "Reload failed: "+err.Error())
+	} else {
+		ws.st.LogEvent(0, EventScript, "", webBy(r), "Reloaded "+MYSCORESCRIPT)
+		go UpdateNZBScores(ws.st)
+	}
+	http.Redirect(w, r, "/rules/", 302)
+}
+
+//Delete a scoring rule and rescore everything
+func (ws *WebServer) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 10, 64)
+	if ws.st.DeleteScoreRule(id) {
+		ws.st.LogEvent(0, EventRule, "", webBy(r), fmt.Sprintf("Deleted rule %d", id))
+		LoadScoreRules(ws.st)
+		go UpdateNZBScores(ws.st)
+	}
+	http.Redirect(w, r, "/rules/", 302)
+}
+
+//Show how release groups and indexers have done
+func (ws *WebServer) GroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groupsstruct := struct {
+		Groups      []SourceStats
+		Indexers    []SourceStats
+		FailLimit   int
+		FailPenalty float64
+	}{ws.st.SourceStats(StatsGroups), ws.st.SourceStats(StatsIndexers), MYFAILLIMIT, MYFAILPENALTY}
+
+	t, ok := templates["GroupsTPL"]
+	if !ok {
+		log.Print("Webstuff:GroupsHandler:Parse")
+		http.Error(w, "TemplateDoesntExist", 500)
+		return
+	}
+	err := t.Execute(w, groupsstruct)
+	if err != nil {
+		log.Print("Webstuff:GroupsHandler:Execute:", err)
+		http.Error(w, "Boom", 500)
+	}
+}
+
+//Block, prefer or clear a group from the link or the form, and rescore
+func (ws *WebServer) GroupPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, policy := vars["name"], vars["policy"]
+	//from the add form rather than a group's buttons
+	if name == "" {
+		name, policy = r.FormValue("name"), r.FormValue("policy")
+	}
+	if policy == "clear" {
+		policy = ""
+	}
+	if name == "" || (policy != "" && policy != GroupBlock && policy != GroupPrefer) {
+		http.Error(w, "Bad group policy", 400)
+		return
+	}
+	ws.st.SetGroupPolicy(name, policy)
+	if policy == "" {
+		ws.st.LogEvent(0, EventGroup, "", webBy(r), "Cleared policy for "+name)
+	} else {
+		ws.st.LogEvent(0, EventGroup, "", webBy(r), fmt.Sprintf("Set %s to %s", name, policy))
+	}
+	LoadSourceStats(ws.st)
+	go UpdateNZBScores(ws.st)
+	http.Redirect(w, r, "/groups/", 302)
+}
+
+//Forget the failures of a group or indexer, and rescore
+func (ws *WebServer) ResetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ws.st.ResetSourceStats(vars["table"], vars["name"])
+	ws.st.LogEvent(0, EventGroup, "", webBy(r), fmt.Sprintf("Reset %s for %s", vars["table"], vars["name"]))
+	LoadSourceStats(ws.st)
+	go UpdateNZBScores(ws.st)
+	http.Redirect(w, r, "/groups/", 302)
+}
+
+//Show movies that have left the watchlist
+func (ws *WebServer) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	mvs := ws.st.ArchivedMovies()
+	for i, mov := range mvs {
+		if mov.CoverUrl != "" {
+			mvs[i].CoverUrl = fmt.Sprintf(`<img height=100 src="%s">`, mov.CoverUrl)
+		}
+	}
+
+	t, ok := templates["ArchiveTPL"]
+	if !ok {
+		log.Print("Webstuff:ArchiveHandler:Parse")
+		http.Error(w, "TemplateDoesntExist", 500)
+		return
+	}
+	err := t.Execute(w, mvs)
+	if err != nil {
+		log.Print("Webstuff:ArchiveHandler:Execute:", err)
+		http.Error(w, "Boom", 500)
+	}
+}
+
+//Put an archived movie back on the list, it'll be archived again
+//next update if it's still not on the watchlist
+func (ws *WebServer) RestoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	movieid, _ := strconv.ParseInt(vars["id"], 10, 64)
+	if ws.st.RestoreMovie(movieid) {
+		ws.st.LogEvent(movieid, EventRestored, "", webBy(r), "")
+	}
+	http.Redirect(w, r, "/archive/", 302)
+}
+
+//Delete an archived movie and everything about it for good,
+//ones still on the watchlist have to be archived first
+func (ws *WebServer) PurgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	movieid, _ := strconv.ParseInt(vars["id"], 10, 64)
+	mov, err := ws.st.Movie(movieid)
+	if err != nil || mov.Archived.IsZero() {
+		log.Printf("Webstuff:PurgeMovieHandler:%d isn't archived:%v", movieid, err)
+		http.Error(w, "Only archived movies can be purged", 400)
+		return
+	}
+	if ws.st.DeleteMovie(movieid) {
+		ws.st.LogEvent(movieid, EventPurged, "", webBy(r), mov.Title)
+	}
+	http.Redirect(w, r, "/archive/", 302)
+}
+
+//Show the event history, filtered by the query string
+//e.g. /history/?kind=failed&by=web&q=matrix&days=7&movie=133093
+func (ws *WebServer) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := EventFilter{Kind: q.Get("kind"), By: q.Get("by"), Search: q.Get("q")}
+	f.MovieId, _ = strconv.ParseInt(q.Get("movie"), 10, 64)
+	f.Days, _ = strconv.Atoi(q.Get("days"))
+
+	historystruct := struct {
+		Filter EventFilter
+		Kinds  []string
+		Events []Event
+	}{f, EventKinds, ws.st.Events(f)}
+
+	t, ok := templates["HistoryTPL"]
+	if !ok {
+		log.Print("Webstuff:HistoryHandler:Parse")
+		http.Error(w, "TemplateDoesntExist", 500)
+		return
+	}
+	err := t.Execute(w, historystruct)
+	if err != nil {
+		log.Print("Webstuff:HistoryHandler:Execute:", err)
+		http.Error(w, "Boom", 500)
+	}
+}
+
+//Take a backup now and download it
+func (ws *WebServer) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := TakeBackup(ws.st, webBy(r))
+	if err != nil {
+		log.Print("Webstuff:BackupHandler:", err)
+		http.Error(w, "Backup failed: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
+
+//By for an event from a web action, with who it came from
+func webBy(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "web " + host
+}
+
+//Show downloads in flight
+func (ws *WebServer) ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	dls := ws.st.Downloads("")
+
+	t, ok := templates["ActivityTPL"]
+	if !ok {
+		log.Print("Webstuff:ActivityHandler:Parse")
+		http.Error(w, "TemplateDoesntExist", 500)
+		return
+	}
+	err := t.Execute(w, dls)
+	if err != nil {
+		log.Print("Webstuff:ActivityHandler:Execute:", err)
+		http.Error(w, "Boom", 500)
+	}
+}
+
 func LoggingMiddleware(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	start := time.Now()
 	next(rw, r)
@@ -147,23 +471,16 @@ func LoggingMiddleware(rw http.ResponseWriter, r *http.Request, next http.Handle
 	log.Printf("%s %s completed %v %s in %v", r.Method, r.URL.Path, res.Status(), http.StatusText(res.Status()), time.Since(start))
 }
 
-func InitWebServer() {
+func InitWebServer(st Store) {
 	log.Println("Webstuff:Init:Begin")
 	DefineTemplates()
-
-	muxrouter := mux.NewRouter()
-	muxrouter.HandleFunc("/", MoviesHandler).Name("allmovies")
-	muxrouter.HandleFunc("/{id:[0-9]+}/", MovieHandler).Name("onemovie")
-	muxrouter.HandleFunc("/getnzb/{id:[0-9]+}/{nzbguid}/", GrabNZBHandler).Name("getnzb")
-	muxrouter.HandleFunc("/refreshnzbs/{id:[0-9]+}/", RefreshNZBHandler).Name("refreshnzbs")
-	muxrouter.HandleFunc("/markungrabbed/{id:[0-9]+}/", MovieUngrabbedHandler).Name("markungrabbed")
-	muxrouter.HandleFunc("/ignorenzb/{id:[0-9]+}/{nzbguid}/{flag:[0-1]}/", NZBIgnoredHandler).Name("ignorenzb")
+	ws := &WebServer{st: st}
 
 	n := negroni.New()
 	recovery := negroni.NewRecovery()
 	n.Use(recovery)
 	n.Use(negroni.HandlerFunc(LoggingMiddleware))
-	n.UseHandler(muxrouter)
+	n.UseHandler(ws.Routes())
 
 	log.Println("Webstuff:Listening on port 5151")
 	err := http.ListenAndServe(":5151", n)
@@ -172,6 +489,35 @@ func InitWebServer() {
 	}
 }
 
+// the pages, and the api under /api/v1
+func (ws *WebServer) Routes() *mux.Router {
+	muxrouter := mux.NewRouter()
+	muxrouter.HandleFunc("/", ws.MoviesHandler).Name("allmovies")
+	muxrouter.HandleFunc("/{id:[0-9]+}/", ws.MovieHandler).Name("onemovie")
+	muxrouter.HandleFunc("/getnzb/{id:[0-9]+}/{nzbguid}/", ws.GrabNZBHandler).Name("getnzb")
+	muxrouter.HandleFunc("/refreshnzbs/{id:[0-9]+}/", ws.RefreshNZBHandler).Name("refreshnzbs")
+	muxrouter.HandleFunc("/markungrabbed/{id:[0-9]+}/", ws.MovieUngrabbedHandler).Name("markungrabbed")
+	muxrouter.HandleFunc("/ignorenzb/{id:[0-9]+}/{nzbguid}/{flag:[0-1]}/", ws.NZBIgnoredHandler).Name("ignorenzb")
+	muxrouter.HandleFunc("/score/{id:[0-9]+}/{nzbguid}/", ws.ScoreHandler).Name("score")
+	muxrouter.HandleFunc("/activity/", ws.ActivityHandler).Name("activity")
+	muxrouter.HandleFunc("/rules/", ws.RulesHandler).Name("rules")
+	muxrouter.HandleFunc("/rules/add/", ws.AddRuleHandler).Methods("POST").Name("addrule")
+	muxrouter.HandleFunc("/rules/reloadscript/", ws.ReloadScriptHandler).Methods("POST").Name("reloadscript")
+	muxrouter.HandleFunc("/rules/delete/{id:[0-9]+}/", ws.DeleteRuleHandler).Methods("POST").Name("deleterule")
+	muxrouter.HandleFunc("/groups/", ws.GroupsHandler).Name("groups")
+	muxrouter.HandleFunc("/groups/add/", ws.GroupPolicyHandler).Methods("POST").Name("addgroup")
+	muxrouter.HandleFunc("/groups/policy/{name}/{policy:block|prefer|clear}/", ws.GroupPolicyHandler).Methods("POST").Name("grouppolicy")
+	muxrouter.HandleFunc("/groups/reset/{table:groupstats|indexerstats}/{name}/", ws.ResetStatsHandler).Methods("POST").Name("resetstats")
+	muxrouter.HandleFunc("/history/", ws.HistoryHandler).Name("history")
+	muxrouter.HandleFunc("/backup/", ws.BackupHandler).Name("backup")
+	muxrouter.HandleFunc("/archive/", ws.ArchiveHandler).Name("archive")
+	muxrouter.HandleFunc("/archive/restore/{id:[0-9]+}/", ws.RestoreMovieHandler).Name("restoremovie")
+	muxrouter.HandleFunc("/archive/purge/{id:[0-9]+}/", ws.PurgeMovieHandler).Methods("POST").Name("purgemovie")
+	muxrouter.HandleFunc("/setprofile/{id:[0-9]+}/{profile}/", ws.MovieProfileHandler).Name("setprofile")
+	ws.APIRoutes(muxrouter.PathPrefix("/api/v1").Subrouter())
+	return muxrouter
+}
+
 func DefineTemplates() {
 	MovieTPL := `
 <!DOCTYPE html>
@@ -193,12 +539,29 @@ func DefineTemplates() {
     <body>
 		<div class="container">
 			<div><h2><a href="/">GoGoMovieDL</a> - {{.MovieName}}</h2></div>
+			<div>
+				Profile <b>{{.Profile.Name}}</b> -
+				{{range $i, $q := .Profile.Qualities}}{{if $i}}, {{end}}{{$q}}{{end}}
+				(cutoff {{.Profile.Cutoff}}){{if .Download.Runtime}} - {{.Download.Runtime}} mins{{end}}
+				<span class="pull-right">Change to
+				{{range .Profiles}}{{if ne .Name $.Profile.Name}} <a href="/setprofile/{{$.MovieId}}/{{.Name}}/">{{.Name}}</a>{{end}}{{end}}
+				</span>
+			</div>
+			{{if .Download.DlGuid}}
+			<div>Downloaded <b>{{.Download.DlQuality}}</b> {{.Download.DlTitle}} ({{printf "%0.2f" .Download.DlScore}})</div>
+			{{end}}
+			{{range .Upgrades}}
+			<div><small>{{.Upgraded.Format "02/01/2006"}} upgraded from {{.OldQuality}} {{.OldTitle}} to {{.NewQuality}} {{.NewTitle}}</small></div>
+			{{end}}
 		<table class="table table-striped table-hover ">
 		<thead>
 		<tr>
 			<th class="ca">Date</th>
 			<th class="ca">Title</th>
+			<th class="ca">Quality</th>
+			<th class="ca">Indexer</th>
 			<th class="ra">Size</th>
+			<th class="ra">Seeds</th>
 			<th class="ra">Score</th>
 			<th class="ca">Grabbed</th>
 			<th class="ca">Grab</th>
@@ -209,9 +572,48 @@ func DefineTemplates() {
         {{range .NZBList}}
 		<tr>
 			<td class="ca">{{.UsenetDate.Format "02/01/2006" }}</td>
-			<td class="la">{{.Title}}</td>
+			<td class="la">{{if eq .Protocol "torrent"}}<i class="fi-magnet" title="Torrent"></i> {{end}}{{.Title}}
+				<br><small>
+				{{with .Resolution}}<span class="label label-primary">{{.}}</span>{{end}}
+				{{with .Source}}<span class="label label-info">{{.}}</span>{{end}}
+				{{with .Codec}}<span class="label label-default">{{.}}</span>{{end}}
+				{{with .HDR}}<span class="label label-warning">{{.}}</span>{{end}}
+				{{if .Audio}}<span class="label label-default">{{.Audio}} {{.Channels}}</span>{{end}}
+				{{with .Edition}}<span class="label label-success">{{.}}</span>{{end}}
+				{{with .Languages}}<span class="label label-danger">{{.}}</span>{{end}}
+				{{if .Proper}}<span class="label label-success">PROPER</span>{{end}}
+				{{if .Repack}}<span class="label label-success">REPACK</span>{{end}}
+				{{with .Group}}<span class="label label-default">{{.}}</span>{{end}}
+				</small>
+				{{if .Sources}}<br><small>Also on
+				{{range .Sources}}<a href="{{.GrabURL}}" title="{{.Title}} {{printf "%0.2f" .Score}}">{{with .Indexer}}{{.}}{{else}}{{.Protocol}}{{end}}</a>{{if eq .Ignored 1}} (ignored){{end}} {{end}}
+				</small>{{end}}
+			</td>
+			<td class="ca">{{if lt .Rank 0}}<s title="Not allowed by profile">{{.Quality}}</s>{{else}}{{.Quality}}{{end}}</td>
+			<td class="ca">{{.Indexer}}</td>
 			<td class="ra">{{ printf "%0.2fGb" .Size}}</td>
-			<td class="ra">{{ printf "%0.2f" .Score}}</td>
+			<td class="ra">{{if eq .Protocol "torrent"}}{{.Seeders}}/{{.Peers}}{{end}}</td>
+			<td class="ra">
+				<details>
+				<summary title="{{.ScoreDetail.String}}">{{ printf "%0.2f" .Score}}</summary>
+				<small>
+				{{with .ScoreDetail}}
+				{{if .Rejected}}<div class="text-danger">{{.Reason}}</div>{{end}}
+				<div>Size {{printf "%0.2f" .Size}}{{if .Expected}} ({{printf "%0.2fGb" .Expected}}){{end}}</div>
+				<div>Age {{printf "%0.2f" .Age}}</div>
+				<div>Words {{printf "%+0.0f" .Words}}</div>
+				{{range .Preferred}}<span class="label label-success">{{.}}</span> {{end}}
+				{{range .Banned}}<span class="label label-danger">{{.}}</span> {{end}}
+				{{if .Rules}}<div>Rules {{printf "%+0.0f" .RuleScore}}</div>{{end}}
+				{{range .Rules}}<div>{{.}}</div>{{end}}
+				{{if .Script}}<div>Script {{printf "%+0.0f" .Script}}</div>{{end}}
+				{{with .ScriptErr}}<div class="text-danger">Script error: {{.}}</div>{{end}}
+				{{range .Sources}}<div>{{.}}</div>{{end}}
+				{{end}}
+				<div><a href="/score/{{.MovieId}}/{{.Id}}/">json</a></div>
+				</small>
+				</details>
+			</td>
 			<td class="ca">{{if eq .Grabbed 1}}<i class="fi-check"></i>{{end}}</td>
 			<td class="ca"><a href="{{.GrabURL}}"><i class="fi-download"></i></a></td>
 			<td class="ca">
@@ -219,6 +621,23 @@ func DefineTemplates() {
 			</td>
 		</tr>
 		{{end}}
+		</tbody>
+		</table>
+		<h4>Timeline <small><a href="/history/?movie={{.MovieId}}">History</a></small></h4>
+		<table class="table table-condensed">
+		<tbody>
+		{{range .Events}}
+		<tr>
+			<td class="la">{{.When.Format "02/01/2006 15:04"}}</td>
+			<td class="la"><span class="label label-default">{{.Kind}}</span></td>
+			<td class="la">{{.Detail}}</td>
+			<td class="la"><small>{{.By}}</small></td>
+		</tr>
+		{{else}}
+		<tr><td class="ca">Nothing yet</td></tr>
+		{{end}}
+		</tbody>
+		</table>
 		</div>
 	</body>
 </html>	
@@ -243,7 +662,8 @@ func DefineTemplates() {
 	</head>
     <body>
 		<div class="container">
-			<div><h2><a href="/">GoGoMovieDL</a></h2></div>
+			<div><h2><a href="/">GoGoMovieDL</a> <small><a href="/activity/">Activity</a> <a href="/rules/">Rules</a> <a href="/groups/">Groups</a> <a href="/archive/">Archive</a> <a href="/history/">History</a></small>
+				<a class="btn btn-default btn-sm pull-right" href="/backup/">Download backup</a></h2></div>
 		<table class="table table-striped table-hover ">
 		<thead>
 		<tr>
@@ -274,6 +694,365 @@ func DefineTemplates() {
 		</div>
 	</body>	
 </html>		
+`
+
+	ActivityTPL := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<meta http-equiv="refresh" content="30">
+		<title>GoGoMovieDL - Activity</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/css/bootstrap.min.css" integrity="sha384-1q8mTJOASx8j1Au+a5WDVnPi2lkFfwwEAa8hDDdjZlpLegxhjVME1fgjWPGmkzs7" crossorigin="anonymous">
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/bootswatch/3.3.6/cosmo/bootstrap.min.css" rel="stylesheet" type="text/css">
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/js/bootstrap.min.js" integrity="sha384-0mSbJDEHialfmuBBQP6A4Qrprq5OVfW37PRR3j5ELqxss1yVqOtnepnHVP9aJ7xS" crossorigin="anonymous"></script>
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/foundicons/3.0.0/foundation-icons.min.css" rel="stylesheet" type="text/css">
+		<style type="text/css">
+			ra {text-align:right;}
+			la {text-align:left;}
+			ca {text-align:center;}
+		</style>
+	</head>
+    <body>
+		<div class="container">
+			<div><h2><a href="/">GoGoMovieDL</a> - Activity</h2></div>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la">Movie</th>
+			<th class="la">Release</th>
+			<th class="ca">Client</th>
+			<th class="ca">Status</th>
+			<th class="ca">Progress</th>
+			<th class="ra">Left</th>
+			<th class="ra">ETA</th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range . }}
+		<tr>
+			<td class="la"><a href="/{{.MovieID}}/">{{.Nicename}}</a></td>
+			<td class="la">{{.Title}}</td>
+			<td class="ca">{{.DlMethod}}</td>
+			<td class="ca">{{ statusName .Status }}</td>
+			<td class="ca">
+				<div class="progress"><div class="progress-bar" style="width: {{.Percentage}}%;">{{.Percentage}}%</div></div>
+			</td>
+			<td class="ra">{{ printf "%0.0fMb" .MBLeft }}</td>
+			<td class="ra">{{.TimeLeft}}</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="7" class="ca">Nothing downloading</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		</div>
+	</body>
+</html>
+`
+
+	RulesTPL := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>GoGoMovieDL - Scoring Rules</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/css/bootstrap.min.css" integrity="sha384-1q8mTJOASx8j1Au+a5WDVnPi2lkFfwwEAa8hDDdjZlpLegxhjVME1fgjWPGmkzs7" crossorigin="anonymous">
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/bootswatch/3.3.6/cosmo/bootstrap.min.css" rel="stylesheet" type="text/css">
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/js/bootstrap.min.js" integrity="sha384-0mSbJDEHialfmuBBQP6A4Qrprq5OVfW37PRR3j5ELqxss1yVqOtnepnHVP9aJ7xS" crossorigin="anonymous"></script>
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/foundicons/3.0.0/foundation-icons.min.css" rel="stylesheet" type="text/css">
+		<style type="text/css">
+			ra {text-align:right;}
+			la {text-align:left;}
+			ca {text-align:center;}
+		</style>
+	</head>
+    <body>
+		<div class="container">
+			<div><h2><a href="/">GoGoMovieDL</a> - Scoring Rules</h2></div>
+			<div>Rules add their weight to the score of every release they match, or reject it.
+			Patterns are case insensitive regexes, size (Gb) and age (days) can also use a comparison like &gt;8.</div>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la">Name</th>
+			<th class="ca">Field</th>
+			<th class="la">Pattern</th>
+			<th class="ra">Weight</th>
+			<th class="ca">From</th>
+			<th class="ca"></th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range .Rules }}
+		<tr>
+			<td class="la">{{.Name}}</td>
+			<td class="ca">{{.Field}}</td>
+			<td class="la"><code>{{.Pattern}}</code></td>
+			<td class="ra">{{if .Reject}}<span class="label label-danger">Reject</span>{{else}}{{printf "%+0.0f" .Weight}}{{end}}</td>
+			<td class="ca">{{if .FromConfig}}config{{else}}web{{end}}</td>
+			<td class="ca">{{if not .FromConfig}}
+				<form method="post" action="/rules/delete/{{.Id}}/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Delete rule"><i class="fi-trash"></i></button>
+				</form>
+			{{end}}</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="6" class="ca">No rules</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		<form class="form-inline" method="post" action="/rules/add/">
+			<input class="form-control" type="text" name="name" placeholder="Name">
+			<select class="form-control" name="field">
+				{{range .Fields}}<option>{{.}}</option>{{end}}
+			</select>
+			<input class="form-control" type="text" name="pattern" placeholder="Pattern">
+			<input class="form-control" type="number" step="any" name="weight" placeholder="Weight">
+			<label><input type="checkbox" name="reject" value="1"> Reject</label>
+			<button class="btn btn-primary" type="submit">Add</button>
+		</form>
+		<h4>Score script</h4>
+		{{if .Script}}
+			<form method="post" action="/rules/reloadscript/"><code>{{.Script}}</code>
+				<button type="submit" class="btn btn-link btn-xs" title="Reload and rescore"><i class="fi-refresh"></i></button>
+			</form>
+		{{else}}
+			<div>No MYSCORESCRIPT set</div>
+		{{end}}
+		{{if not .ScriptError.When.IsZero}}
+			<div class="alert alert-danger">{{.ScriptError.When.Format "02/01/2006 15:04"}} {{.ScriptError.Message}}</div>
+		{{end}}
+		</div>
+	</body>
+</html>
+`
+
+	GroupsTPL := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>GoGoMovieDL - Groups</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/css/bootstrap.min.css" integrity="sha384-1q8mTJOASx8j1Au+a5WDVnPi2lkFfwwEAa8hDDdjZlpLegxhjVME1fgjWPGmkzs7" crossorigin="anonymous">
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/bootswatch/3.3.6/cosmo/bootstrap.min.css" rel="stylesheet" type="text/css">
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/js/bootstrap.min.js" integrity="sha384-0mSbJDEHialfmuBBQP6A4Qrprq5OVfW37PRR3j5ELqxss1yVqOtnepnHVP9aJ7xS" crossorigin="anonymous"></script>
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/foundicons/3.0.0/foundation-icons.min.css" rel="stylesheet" type="text/css">
+		<style type="text/css">
+			ra {text-align:right;}
+			la {text-align:left;}
+			ca {text-align:center;}
+		</style>
+	</head>
+    <body>
+		<div class="container">
+			<div><h2><a href="/">GoGoMovieDL</a> - Groups</h2></div>
+			<div>Groups and indexers that fail {{.FailLimit}} times, more than they complete, lose {{printf "%0.0f" .FailPenalty}} points a failure.</div>
+		<h4>Release Groups</h4>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la">Group</th>
+			<th class="ra">Completed</th>
+			<th class="ra">Failed</th>
+			<th class="ca">Last Failed</th>
+			<th class="ra">Penalty</th>
+			<th class="ca">Policy</th>
+			<th class="ca"></th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range .Groups }}
+		<tr>
+			<td class="la">{{.Name}}</td>
+			<td class="ra">{{.Completed}}</td>
+			<td class="ra">{{.Failed}}</td>
+			<td class="ca">{{if not .LastFailed.IsZero}}{{.LastFailed.Format "02/01/2006"}}{{end}}</td>
+			<td class="ra">{{printf "%0.0f" .Penalty}}</td>
+			<td class="ca">{{if eq .Policy "block"}}<span class="label label-danger">Blocked</span>{{else if eq .Policy "prefer"}}<span class="label label-success">Preferred</span>{{end}}</td>
+			<td class="ca">
+				<form method="post" action="/groups/policy/{{.Name}}/prefer/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Prefer"><i class="fi-like"></i></button>
+				</form>
+				<form method="post" action="/groups/policy/{{.Name}}/block/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Block"><i class="fi-prohibited"></i></button>
+				</form>
+				<form method="post" action="/groups/policy/{{.Name}}/clear/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Clear policy"><i class="fi-x"></i></button>
+				</form>
+				<form method="post" action="/groups/reset/groupstats/{{.Name}}/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Forget failures"><i class="fi-refresh"></i></button>
+				</form>
+			</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="7" class="ca">No downloads yet</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		<form class="form-inline" method="post" action="/groups/add/">
+			<input class="form-control" type="text" name="name" placeholder="Group">
+			<select class="form-control" name="policy">
+				<option>prefer</option>
+				<option>block</option>
+			</select>
+			<button class="btn btn-primary" type="submit">Add</button>
+		</form>
+		<h4>Indexers</h4>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la">Indexer</th>
+			<th class="ra">Completed</th>
+			<th class="ra">Failed</th>
+			<th class="ca">Last Failed</th>
+			<th class="ra">Penalty</th>
+			<th class="ca"></th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range .Indexers }}
+		<tr>
+			<td class="la">{{.Name}}</td>
+			<td class="ra">{{.Completed}}</td>
+			<td class="ra">{{.Failed}}</td>
+			<td class="ca">{{if not .LastFailed.IsZero}}{{.LastFailed.Format "02/01/2006"}}{{end}}</td>
+			<td class="ra">{{printf "%0.0f" .Penalty}}</td>
+			<td class="ca">
+				<form method="post" action="/groups/reset/indexerstats/{{.Name}}/" style="display:inline">
+					<button type="submit" class="btn btn-link btn-xs" title="Forget failures"><i class="fi-refresh"></i></button>
+				</form>
+			</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="6" class="ca">No downloads yet</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		</div>
+	</body>
+</html>
+`
+
+	ArchiveTPL := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>GoGoMovieDL - Archive</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/css/bootstrap.min.css" integrity="sha384-1q8mTJOASx8j1Au+a5WDVnPi2lkFfwwEAa8hDDdjZlpLegxhjVME1fgjWPGmkzs7" crossorigin="anonymous">
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/bootswatch/3.3.6/cosmo/bootstrap.min.css" rel="stylesheet" type="text/css">
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/js/bootstrap.min.js" integrity="sha384-0mSbJDEHialfmuBBQP6A4Qrprq5OVfW37PRR3j5ELqxss1yVqOtnepnHVP9aJ7xS" crossorigin="anonymous"></script>
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/foundicons/3.0.0/foundation-icons.min.css" rel="stylesheet" type="text/css">
+		<style type="text/css">
+			ra {text-align:right;}
+			la {text-align:left;}
+			ca {text-align:center;}
+		</style>
+	</head>
+    <body>
+		<div class="container">
+			<div><h2><a href="/">GoGoMovieDL</a> - Archive</h2></div>
+			<div>Movies that left the watchlist. Restoring puts one back on the list, until the next update if it's still not on the watchlist. Purging deletes it and its releases.</div>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la"></th>
+			<th class="la">Title</th>
+			<th class="ca">Archived</th>
+			<th class="ra">NZBs</th>
+			<th class="la">Downloaded</th>
+			<th class="ca"></th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range . }}
+		<tr>
+			<td class="la">{{safeHTML .CoverUrl}}</td>
+			<td class="la">{{.Title}}</td>
+			<td class="ca">{{.Archived.Format "02/01/2006 15:04"}}</td>
+			<td class="ra">{{.NzbCount}}</td>
+			<td class="la">{{.DlTitle}}</td>
+			<td class="ca">
+				<a href="/archive/restore/{{.Id}}/" title="Restore"><i class="fi-arrow-left"></i></a>
+				<form method="post" action="/archive/purge/{{.Id}}/" style="display:inline" onsubmit="return confirm('Delete {{.Title}} for good?')">
+					<button type="submit" class="btn btn-link btn-xs" title="Purge"><i class="fi-trash"></i></button>
+				</form>
+			</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="6" class="ca">Nothing archived</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		</div>
+	</body>
+</html>
+`
+
+	HistoryTPL := `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<title>GoGoMovieDL - History</title>
+		<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/css/bootstrap.min.css" integrity="sha384-1q8mTJOASx8j1Au+a5WDVnPi2lkFfwwEAa8hDDdjZlpLegxhjVME1fgjWPGmkzs7" crossorigin="anonymous">
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/bootswatch/3.3.6/cosmo/bootstrap.min.css" rel="stylesheet" type="text/css">
+		<script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.6/js/bootstrap.min.js" integrity="sha384-0mSbJDEHialfmuBBQP6A4Qrprq5OVfW37PRR3j5ELqxss1yVqOtnepnHVP9aJ7xS" crossorigin="anonymous"></script>
+		<link href="https://cdnjs.cloudflare.com/ajax/libs/foundicons/3.0.0/foundation-icons.min.css" rel="stylesheet" type="text/css">
+		<style type="text/css">
+			ra {text-align:right;}
+			la {text-align:left;}
+			ca {text-align:center;}
+		</style>
+	</head>
+    <body>
+		<div class="container">
+			<div><h2><a href="/">GoGoMovieDL</a> - History</h2></div>
+		<form class="form-inline" method="get" action="/history/">
+			{{if .Filter.MovieId}}<input type="hidden" name="movie" value="{{.Filter.MovieId}}">{{end}}
+			<select class="form-control" name="kind">
+				<option value="">Everything</option>
+				{{range .Kinds}}<option{{if eq . $.Filter.Kind}} selected{{end}}>{{.}}</option>{{end}}
+			</select>
+			<input class="form-control" type="text" name="by" value="{{.Filter.By}}" placeholder="By e.g. web">
+			<input class="form-control" type="text" name="q" value="{{.Filter.Search}}" placeholder="Movie or detail">
+			<input class="form-control" type="number" name="days" value="{{if .Filter.Days}}{{.Filter.Days}}{{end}}" placeholder="Days">
+			<button class="btn btn-primary" type="submit">Filter</button>
+			{{if .Filter.MovieId}}<a href="/history/">All movies</a>{{end}}
+		</form>
+		<table class="table table-striped table-hover ">
+		<thead>
+		<tr>
+			<th class="la">When</th>
+			<th class="la">Movie</th>
+			<th class="la">What</th>
+			<th class="la">Detail</th>
+			<th class="la">By</th>
+		</tr>
+		</thead>
+		<tbody>
+{{ range .Events }}
+		<tr>
+			<td class="la">{{.When.Format "02/01/2006 15:04"}}</td>
+			<td class="la">{{if .MovieId}}<a href="/history/?movie={{.MovieId}}">{{or .MovieTitle .MovieId}}</a>{{end}}</td>
+			<td class="la"><a href="/history/?kind={{.Kind}}"><span class="label label-default">{{.Kind}}</span></a></td>
+			<td class="la">{{.Detail}}</td>
+			<td class="la">{{.By}}</td>
+		</tr>
+{{ else }}
+		<tr><td colspan="5" class="ca">Nothing happened</td></tr>
+{{end}}
+		</tbody>
+		</table>
+		</div>
+	</body>
+</html>
 `
 
 	if templates == nil {
@@ -285,6 +1064,18 @@ func DefineTemplates() {
 
 	templates["MovieTPL"] = template.Must(template.New("MovieTPL").Funcs(template.FuncMap{
 		"safeHTML": safeHTML}).Parse(MovieTPL))
+
+	templates["ActivityTPL"] = template.Must(template.New("ActivityTPL").Funcs(template.FuncMap{
+		"statusName": DownloadStatusName}).Parse(ActivityTPL))
+
+	templates["RulesTPL"] = template.Must(template.New("RulesTPL").Parse(RulesTPL))
+
+	templates["GroupsTPL"] = template.Must(template.New("GroupsTPL").Parse(GroupsTPL))
+
+	templates["HistoryTPL"] = template.Must(template.New("HistoryTPL").Parse(HistoryTPL))
+
+	templates["ArchiveTPL"] = template.Must(template.New("ArchiveTPL").Funcs(template.FuncMap{
+		"safeHTML": safeHTML}).Parse(ArchiveTPL))
 }
 
 // safeHTML returns a given string as html/template HTML content.
@@ -0,0 +1,87 @@
+//webstuff_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// status and body of a request to the web pages
+func testPage(h http.Handler, method string, url string) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w.Code, w.Body.String()
+}
+
+// a prefetch or a crawler following the links mustn't change anything
+func TestRulesPostOnly(t *testing.T) {
+	testConfig(t)
+	DefineTemplates()
+	st := NewMemoryStore()
+	web := (&WebServer{st: st}).Routes()
+	st.AddScoreRule(ScoreRule{Name: "no hevc", Field: "title", Pattern: "hevc", Weight: -100})
+	id := st.ScoreRules()[0].Id
+
+	code, body := testPage(web, "GET", "/rules/")
+	if code != http.StatusOK || !strings.Contains(body, `<form method="post" action="/rules/delete/`) {
+		t.Errorf("rules page %d has no delete form", code)
+	}
+	deleteurl := fmt.Sprintf("/rules/delete/%d/", id)
+	for _, url := range []string{deleteurl, "/rules/reloadscript/"} {
+		if code, _ := testPage(web, "GET", url); code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s = %d", url, code)
+		}
+	}
+	if len(st.ScoreRules()) != 1 {
+		t.Fatalf("rule deleted by a GET")
+	}
+	if code, _ := testPage(web, "POST", deleteurl); code != http.StatusFound || len(st.ScoreRules()) != 0 {
+		t.Errorf("POST delete = %d, rules %+v", code, st.ScoreRules())
+	}
+}
+
+func TestGroupsPostOnly(t *testing.T) {
+	testConfig(t)
+	DefineTemplates()
+	st := NewMemoryStore()
+	web := (&WebServer{st: st}).Routes()
+	st.RecordSourceResult(StatsGroups, "GRP", false)
+	st.RecordSourceResult(StatsIndexers, "one", false)
+
+	code, body := testPage(web, "GET", "/groups/")
+	for _, form := range []string{`action="/groups/policy/GRP/block/"`, `action="/groups/reset/groupstats/GRP/"`, `action="/groups/reset/indexerstats/one/"`} {
+		if code != http.StatusOK || !strings.Contains(body, `<form method="post" `+form) {
+			t.Errorf("groups page %d has no form %s", code, form)
+		}
+	}
+	for _, url := range []string{"/groups/policy/GRP/block/", "/groups/reset/groupstats/GRP/", "/groups/reset/indexerstats/one/"} {
+		if code, _ := testPage(web, "GET", url); code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s = %d", url, code)
+		}
+	}
+	if gs := st.SourceStats(StatsGroups); len(gs) != 1 || gs[0].Policy != "" || gs[0].Failed != 1 {
+		t.Fatalf("changed by a GET %+v", gs)
+	}
+
+	if code, _ := testPage(web, "POST", "/groups/policy/GRP/block/"); code != http.StatusFound {
+		t.Errorf("POST policy = %d", code)
+	}
+	if code, _ := testPage(web, "POST", "/groups/reset/groupstats/GRP/"); code != http.StatusFound {
+		t.Errorf("POST reset = %d", code)
+	}
+	if gs := st.SourceStats(StatsGroups); len(gs) != 1 || gs[0].Policy != GroupBlock || gs[0].Failed != 0 {
+		t.Errorf("after POSTs %+v", gs)
+	}
+
+	//the add form posts the name and policy
+	if code, _ := testPage(web, "POST", "/groups/add/?name=NEW&policy=prefer"); code != http.StatusFound {
+		t.Errorf("POST add = %d", code)
+	}
+	LoadSourceStats(st)
+	if GroupStatsFor("NEW").Policy != GroupPrefer {
+		t.Errorf("added group %+v", GroupStatsFor("NEW"))
+	}
+}